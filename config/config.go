@@ -1,15 +1,45 @@
 package config
 
 import (
+  "encoding/json"
   "fmt"
+  "io/ioutil"
   "strings"
   "strconv"
   "os"
+  "text/template"
 )
 
+// Controls which goroutines are listed as threads in devtools.
+type GoroutineFilter struct {
+  UserOnly bool // Only goroutines that are running or were started in code outside of GOROOT.
+  HideSystem bool // Hide goroutines the go runtime starts for itself.
+  GroupByStart bool // Show one thread per 'go' statement instead of one per goroutine.
+}
+
+// How much of a value is loaded from delve at once. Anything past these is loaded when the value is expanded.
+type LoadLimits struct {
+  MaxStringLen int
+  MaxArrayValues int
+  CopyDepth int // How deep values are followed when they are copied as go or json.
+}
+
+// Shows values of Type (full type name, like "example.com/money.Amount") using Template, a text/template that
+// gets the fields of the value by name, or .Value for values that are not structs.
+type Formatter struct {
+  Type string `json:"type"`
+  Template string `json:"template"`
+}
+
 type Config struct {
   Port string
   DlvPath string
+  GoroutineFilter GoroutineFilter
+  ShowThreads bool
+  Verbose bool
+  Callsites bool
+  LoadLimits LoadLimits
+  Formatters []Formatter
   DebugSession struct {
     File string
     Args []string
@@ -50,10 +80,145 @@ func dlvFromArg(c *Config, dlvPath string) bool {
   return true
 }
 
+func goroutinesFromArg(c *Config, value string) bool {
+  filter, ok := ParseGoroutineFilter(value)
+  if !ok {
+    fmt.Println("Value for 'goroutines' must be a comma separated list of: all, user, hide-system, group.")
+    return false
+  }
+  c.GoroutineFilter = filter
+  return true
+}
+
+// Parses a comma separated list like "user,group". "all" turns every filter off.
+func ParseGoroutineFilter(value string) (GoroutineFilter, bool) {
+  filter := GoroutineFilter{}
+  for _, option := range strings.Split(value, ",") {
+    switch strings.TrimSpace(option) {
+    case "all", "":
+      filter = GoroutineFilter{}
+    case "user":
+      filter.UserOnly = true
+    case "hide-system":
+      filter.HideSystem = true
+    case "group":
+      filter.GroupByStart = true
+    default:
+      return filter, false
+    }
+  }
+  return filter, true
+}
+
+// The filter in the form ParseGoroutineFilter takes.
+func (f GoroutineFilter) String() string {
+  options := []string{}
+  if f.UserOnly {
+    options = append(options, "user")
+  }
+  if f.HideSystem {
+    options = append(options, "hide-system")
+  }
+  if f.GroupByStart {
+    options = append(options, "group")
+  }
+  if len(options) == 0 {
+    return "all"
+  }
+  return strings.Join(options, ",")
+}
+
+func threadsFromArg(c *Config, value string) bool {
+  // Passing just '--threads' gives us the flag name as value.
+  if value == "--threads" {
+    value = "true"
+  }
+  show, err := strconv.ParseBool(value)
+  if err != nil {
+    fmt.Println("Value for 'threads' must be true or false.")
+    return false
+  }
+  c.ShowThreads = show
+  return true
+}
+
+func verboseFromArg(c *Config, value string) bool {
+  // Passing just '--verbose' gives us the flag name as value.
+  if value == "--verbose" {
+    value = "true"
+  }
+  verbose, err := strconv.ParseBool(value)
+  if err != nil {
+    fmt.Println("Value for 'verbose' must be true or false.")
+    return false
+  }
+  c.Verbose = verbose
+  return true
+}
+
+func callsitesFromArg(c *Config, value string) bool {
+  // Passing just '--callsites' gives us the flag name as value.
+  if value == "--callsites" {
+    value = "true"
+  }
+  callsites, err := strconv.ParseBool(value)
+  if err != nil {
+    fmt.Println("Value for 'callsites' must be true or false.")
+    return false
+  }
+  c.Callsites = callsites
+  return true
+}
+
+func positiveIntFromArg(name string, out *int, value string) bool {
+  number, err := strconv.Atoi(value)
+  if err != nil || number <= 0 {
+    fmt.Println("Value for '" + name + "' must be a positive number.")
+    return false
+  }
+  *out = number
+  return true
+}
+
+func formattersFromArg(c *Config, file string) bool {
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    fmt.Println("Could not read formatters file: " + err.Error())
+    return false
+  }
+  var formatters []Formatter
+  if err := json.Unmarshal(data, &formatters); err != nil {
+    fmt.Println("Invalid formatters file: " + err.Error())
+    return false
+  }
+  for _, formatter := range formatters {
+    if _, err := template.New(formatter.Type).Parse(formatter.Template); err != nil {
+      fmt.Println("Invalid template for '" + formatter.Type + "': " + err.Error())
+      return false
+    }
+  }
+  c.Formatters = append(c.Formatters, formatters...)
+  return true
+}
+
 var boundArgsInfo = map[string]func(*Config, string)bool{
   "0": fileFromArg,
   "--port": portFromArg,
   "--dlv": dlvFromArg,
+  "--goroutines": goroutinesFromArg,
+  "--threads": threadsFromArg,
+  "--verbose": verboseFromArg,
+  "--callsites": callsitesFromArg,
+  "--max-string-len": func (c *Config, value string) bool {
+    return positiveIntFromArg("max-string-len", &c.LoadLimits.MaxStringLen, value)
+  },
+  "--max-array-values": func (c *Config, value string) bool {
+    return positiveIntFromArg("max-array-values", &c.LoadLimits.MaxArrayValues, value)
+  },
+  "--copy-depth": func (c *Config, value string) bool {
+    return positiveIntFromArg("copy-depth", &c.LoadLimits.CopyDepth, value)
+  },
+  "--formatters": formattersFromArg,
   "--help": func (_ *Config, _ string) bool {
     printHelp()
     return false
@@ -81,6 +246,14 @@ func NewFromArgs(args []string) *Config {
   config := &Config{
     Port: "9922",
     DlvPath: defaultDlvPath,
+    GoroutineFilter: GoroutineFilter{
+      HideSystem: true,
+    },
+    LoadLimits: LoadLimits{
+      MaxStringLen: 500,
+      MaxArrayValues: 100,
+      CopyDepth: 10,
+    },
   }
 
   curNumbPos := 0
@@ -133,6 +306,23 @@ func printHelp() {
     "                     front-end. Default 9922.",
     "  --dlv=FILE         Location of DLV installed on machine. Default (tries to",
     "                     find 'dlv' in system path.",
+    "  --goroutines=LIST  Which goroutines to list as threads. Comma separated",
+    "                     list of: all, user, hide-system, group. Default",
+    "                     hide-system.",
+    "  --threads          Also list the OS threads of the program next to the",
+    "                     goroutines.",
+    "  --max-string-len=N Number of bytes of a string loaded at once. Default 500.",
+    "  --max-array-values=N",
+    "                     Number of slice, array or map entries loaded at once.",
+    "                     Bigger values are split in groups. Default 100.",
+    "  --copy-depth=N     How deep values are followed when copied as go or json.",
+    "                     Deeper values are left out. Default 10.",
+    "  --formatters=FILE  Json file with formatters for your own types, like",
+    "                     [{\"type\": \"main.Money\",",
+    "                       \"template\": \"{{.Units}}.{{.Cents}} {{.Currency}}\"}]",
+    "  --verbose          Also print the output of the program to the terminal.",
+    "  --callsites        Link every line the program prints to the code that",
+    "                     printed it. Slows down programs that write a lot.",
     "  --help             Prints this help dialog.",
     "",
   }
@@ -49,3 +49,16 @@ func (c *Client) ClearBreakpointByName(name string) error {
     _, err := c.rpcClient.ClearBreakpointByName(name)
     return err
 }
+
+// Tracepoints do not stop the program for us, delve collects the arguments and stackDepth frames and carries on.
+func (c *Client) CreateTracepoint(function string, name string, stackDepth int, cfg LoadConfig) (*Breakpoint, error) {
+    loadArgs := api.LoadConfig(cfg)
+    breakpoint, err := c.rpcClient.CreateBreakpoint(&api.Breakpoint{
+        FunctionName: function,
+        Name: name,
+        Tracepoint: true,
+        Stacktrace: stackDepth,
+        LoadArgs: &loadArgs,
+    })
+    return (*Breakpoint)(breakpoint), err
+}
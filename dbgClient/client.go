@@ -137,6 +137,11 @@ func (c *Client) GetStderr() (io.ReadCloser, error) {
     return c.stderr, nil
 }
 
+func (c *Client) GetStdin() (io.WriteCloser, error) {
+    c.BlockUntilReady()
+    return c.stdin, nil
+}
+
 func (c *Client) setupBreakOnStart() {
     scope, err := c.FindLocation(EvalScope{
         GoroutineID: -1,
@@ -164,4 +169,14 @@ func (c *Client) FindLocation(scope EvalScope, location string) ([]Location, err
 
 func (c *Client) ListSources() ([]string, error) {
     return c.rpcClient.ListSources("")
-}
\ No newline at end of file
+}
+
+// Names of the functions in the program matching the regexp filter.
+func (c *Client) ListFunctions(filter string) ([]string, error) {
+    return c.rpcClient.ListFunctions(filter)
+}
+
+// Names of the types in the program matching the regexp filter.
+func (c *Client) ListTypes(filter string) ([]string, error) {
+    return c.rpcClient.ListTypes(filter)
+}
@@ -1,6 +1,7 @@
 package dbgClient
 
 import (
+    "fmt"
     "unsafe"
     "github.com/derekparker/delve/service/api"
 )
@@ -20,6 +21,17 @@ func (c *Client) Stacktrace(goroutineID int, depth int, cfg *LoadConfig) ([]Stac
     return *(*[]Stackframe)(unsafe.Pointer(&stack)), err
 }
 
+func (c *Client) ListRegisters(threadID int, includeFp bool) ([]Register, error) {
+    registers, err := c.rpcClient.ListRegisters(threadID, includeFp)
+    return *(*[]Register)(unsafe.Pointer(&registers)), err
+}
+
+// Instructions of the function pc is in, in intel syntax.
+func (c *Client) DisassemblePC(scope EvalScope, pc uint64) ([]AsmInstruction, error) {
+    instructions, err := c.rpcClient.DisassemblePC(scope.conv(), pc, api.IntelFlavour)
+    return *(*[]AsmInstruction)(unsafe.Pointer(&instructions)), err
+}
+
 func (c *Client) GetState() (*DebuggerState, error) {
     debuggerState, err := c.rpcClient.GetState()
     return (*DebuggerState)(debuggerState), err
@@ -64,3 +76,87 @@ func (c *Client) EvalVariable(scope EvalScope, expr string, cfg LoadConfig) (*Va
     variable, err := c.rpcClient.EvalVariable(api.EvalScope(scope), expr, api.LoadConfig(cfg))
     return (*Variable)(variable), err
 }
+
+func (c *Client) Halt() (*DebuggerState, error) {
+    debuggerState, err := c.rpcClient.Halt()
+    return (*DebuggerState)(debuggerState), err
+}
+
+// Runs a function call expression in the selected goroutine. Needs a delve with function call injection (1.1+),
+// the results are in the ReturnValues of the current thread.
+func (c *Client) Call(expr string, unsafeCall bool) (*DebuggerState, error) {
+    debuggerState, err := c.rpcClient.Call(expr, unsafeCall)
+    return (*DebuggerState)(debuggerState), err
+}
+
+// Starts the program over and runs it to main like Start does. Breakpoints are kept, the ones delve could not set
+// again in the new process are returned.
+func (c *Client) Restart() ([]DiscardedBreakpoint, error) {
+    discarded, err := c.rpcClient.Restart()
+    if err != nil {
+        return nil, err
+    }
+    if err := c.runToMain(); err != nil {
+        return nil, err
+    }
+    // This pattern is here because we cannot convert between slices of same underlying types but different toplevel types.
+    return *(*[]DiscardedBreakpoint)(unsafe.Pointer(&discarded)), nil
+}
+
+// Unlike Start this has to leave the user's breakpoints alone, so only the one it made is cleared.
+func (c *Client) runToMain() error {
+    locations, err := c.FindLocation(EvalScope{GoroutineID: -1}, "main.main")
+    if err != nil {
+        return err
+    }
+    if len(locations) < 1 {
+        return fmt.Errorf("Could not find main.main")
+    }
+    mainPC := locations[0].PC
+    breakpoints, err := c.ListAllBreakpoints()
+    if err != nil {
+        return err
+    }
+    userStopsThere := false
+    for _, breakpoint := range breakpoints {
+        userStopsThere = userStopsThere || breakpoint.Addr == mainPC
+    }
+    var breakpoint *api.Breakpoint
+    if !userStopsThere {
+        if breakpoint, err = c.rpcClient.CreateBreakpoint(&api.Breakpoint{Addr: mainPC}); err != nil {
+            return err
+        }
+    }
+    // Breakpoints of the user in init() functions stop the program on the way, Start leaves it at main.main.
+    for {
+        state, ok := c.ContinueToStop(nil)
+        if !ok || state == nil {
+            return fmt.Errorf("Lost the process before reaching main")
+        }
+        if state.Exited {
+            return fmt.Errorf("Process exited with status %d before reaching main", state.ExitStatus)
+        }
+        if state.CurrentThread != nil && state.CurrentThread.PC == mainPC {
+            break
+        }
+    }
+    if breakpoint == nil {
+        return nil
+    }
+    return c.ClearBreakpoint(breakpoint.ID)
+}
+
+// Continue sends a state for every tracepoint it goes past, the last one is where it stopped. each, if not nil, sees
+// every one of them. ok is false if delve closed the channel without telling us anything.
+func (c *Client) ContinueToStop(each func(*DebuggerState)) (*DebuggerState, bool) {
+    var last *DebuggerState
+    ok := false
+    for state := range c.Continue() {
+        if each != nil {
+            each(state)
+        }
+        last = state
+        ok = true
+    }
+    return last, ok
+}
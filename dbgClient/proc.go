@@ -3,3 +3,7 @@ package dbgClient
 func (c *Client) Detach(kill bool) error {
     return c.rpcClient.Detach(true)
 }
+
+func (c *Client) ProcessPid() int {
+    return c.rpcClient.ProcessPid()
+}
@@ -1,6 +1,10 @@
 package dbgClient
 
 import (
+    "go/build"
+    "path/filepath"
+    "strings"
+    "unsafe"
     "github.com/derekparker/delve/service/api"
 )
 
@@ -16,6 +20,14 @@ func (a Location) conv() api.Location {
     return api.Location(a)
 }
 
+// Files of the go installation, the runtime and the standard library.
+func IsGorootFile(file string) bool {
+    if file == "" || build.Default.GOROOT == "" {
+        return false
+    }
+    return strings.HasPrefix(filepath.Clean(file), filepath.Clean(build.Default.GOROOT) + string(filepath.Separator))
+}
+
 type Breakpoint api.Breakpoint
 
 func (a Breakpoint) conv() api.Breakpoint {
@@ -34,6 +46,12 @@ func (a Goroutine) conv() api.Goroutine {
     return api.Goroutine(a)
 }
 
+// Values of Goroutine.Status we care about.
+const (
+    GoroutineWaiting = api.GoroutineWaiting
+    GoroutineSyscall = api.GoroutineSyscall
+)
+
 type LoadConfig api.LoadConfig
 
 func (a LoadConfig) conv() api.LoadConfig {
@@ -57,3 +75,31 @@ type Variable api.Variable
 func (a Variable) conv() api.Variable {
     return api.Variable(a)
 }
+
+// Delve's slices inside our types, like Variable.Children, as slices of our types. Go cannot convert between
+// slices whose elements only share the underlying type, so it is done by pointer.
+func Variables(variables []api.Variable) []Variable {
+    return *(*[]Variable)(unsafe.Pointer(&variables))
+}
+
+func Stackframes(frames []api.Stackframe) []Stackframe {
+    return *(*[]Stackframe)(unsafe.Pointer(&frames))
+}
+
+type DiscardedBreakpoint api.DiscardedBreakpoint
+
+func (a DiscardedBreakpoint) conv() api.DiscardedBreakpoint {
+    return api.DiscardedBreakpoint(a)
+}
+
+type Register api.Register
+
+func (a Register) conv() api.Register {
+    return api.Register(a)
+}
+
+type AsmInstruction api.AsmInstruction
+
+func (a AsmInstruction) conv() api.AsmInstruction {
+    return api.AsmInstruction(a)
+}
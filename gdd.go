@@ -25,22 +25,36 @@ func (h handler) handleDebugRequest(conn *shared.Connection) {
     }
     go client.Start(h.Config)
 
+    runtimeProxy := runtime.NewProxy(conn, client)
+    runtimeProxy.SetLoadLimits(h.Config.LoadLimits)
+    runtimeProxy.SetVerbose(h.Config.Verbose)
+    runtimeProxy.SetCallsites(h.Config.Callsites)
+    if err := runtimeProxy.SetFormatters(h.Config.Formatters); err != nil {
+        fmt.Println("Invalid formatter: " + err.Error())
+    }
+
     go func() {
         for {
             // This just ensures we syncronize our sockets if either dies, they both die.
             if client.Killed() || conn.Closed() {
                 client.Kill()
                 conn.Close()
+                runtimeProxy.StopSnippets()
                 return
             }
             time.Sleep(300 * time.Millisecond)
         }
     }()
 
-    runtimeProxy := runtime.NewProxy(conn, client)
     go runtimeProxy.Start()
-    go debugger.NewProxy(conn, client).Start(runtimeProxy)
-    go page.NewProxy(conn, client).Start()
+    debuggerProxy := debugger.NewProxy(conn, client)
+    debuggerProxy.SetGoroutineFilter(h.Config.GoroutineFilter)
+    debuggerProxy.SetShowThreads(h.Config.ShowThreads)
+    runtimeProxy.SetDebuggerSettings(debuggerProxy)
+    go debuggerProxy.Start(runtimeProxy)
+    pageProxy := page.NewProxy(conn, client)
+    pageProxy.SetRestartHandler(debuggerProxy.Restart)
+    go pageProxy.Start()
 }
 
 func printHelp() {
@@ -49,6 +49,7 @@ type LogEntry struct {
     StackTrace *runtime.StackTrace `json:"stackTrace,omitempty"`// JavaScript stack trace.
     NetworkRequestId *network.RequestId `json:"networkRequestId,omitempty"`// Identifier of the network request associated with this entry.
     WorkerId *string `json:"workerId,omitempty"`// Identifier of the worker associated with this entry.
+    Args *[]runtime.RemoteObject `json:"args,omitempty"`// Call arguments.
 }
 
 type ViolationSetting struct {
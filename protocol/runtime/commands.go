@@ -18,6 +18,7 @@ type EvaluateCommand struct {
     GeneratePreview *bool `json:"generatePreview,omitempty"`// [Experimental] Whether preview should be generated for the result.
     UserGesture *bool `json:"userGesture,omitempty"`// [Experimental] Whether execution should be treated as initiated by user in the UI.
     AwaitPromise *bool `json:"awaitPromise,omitempty"`// Whether execution should wait for promise to be resolved. If the result of evaluation is not a Promise, it's considered to be an error.
+    ThrowOnSideEffect *bool `json:"throwOnSideEffect,omitempty"`// [Experimental] Whether to throw an exception if side effect cannot be ruled out during evaluation.
 }
 type EvaluateReturn struct {
     Result RemoteObject `json:"result"`// Evaluation result.
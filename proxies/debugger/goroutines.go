@@ -0,0 +1,134 @@
+package debugger
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "github.com/allada/gdd/config"
+    "github.com/allada/gdd/dbgClient"
+)
+
+type goroutineDescription struct {
+    Title string
+    Url string
+    State string // Where the goroutine is and what it waits on. Changes all the time, so it goes in the paused event.
+}
+
+func functionName(location dbgClient.Location) string {
+    if location.Function == nil {
+        return ""
+    }
+    return location.Function.Name
+}
+
+func shortFunctionName(location dbgClient.Location) string {
+    name := functionName(location)
+    if name == "" {
+        return "<Unknown>"
+    }
+    // Keep the package name, drop the import path.
+    return name[strings.LastIndex(name, "/") + 1:]
+}
+
+// Goroutines the go runtime starts for itself, like the GC workers.
+func isSystemGoroutine(routine dbgClient.Goroutine) bool {
+    return strings.HasPrefix(functionName(dbgClient.Location(routine.GoStatementLoc)), "runtime.") &&
+        (routine.UserCurrentLoc.Function == nil || strings.HasPrefix(functionName(dbgClient.Location(routine.UserCurrentLoc)), "runtime."))
+}
+
+func isUserGoroutine(routine dbgClient.Goroutine) bool {
+    return !dbgClient.IsGorootFile(routine.UserCurrentLoc.File) || !dbgClient.IsGorootFile(routine.GoStatementLoc.File)
+}
+
+func filterHidesGoroutine(filter config.GoroutineFilter, routine dbgClient.Goroutine) bool {
+    if filter.HideSystem && isSystemGoroutine(routine) {
+        return true
+    }
+    return filter.UserOnly && !isUserGoroutine(routine)
+}
+
+// Goroutines started by the same 'go' statement end up in the same group.
+func goroutineGroupKey(routine dbgClient.Goroutine) string {
+    return fmt.Sprintf("%s:%d", routine.GoStatementLoc.File, routine.GoStatementLoc.Line)
+}
+
+// What the goroutine is blocked on. waitReasons are the names the program's runtime has for Goroutine.WaitReason.
+func waitReason(routine dbgClient.Goroutine, waitReasons []string) string {
+    if routine.ThreadID != 0 {
+        return "running"
+    }
+    switch routine.Status {
+    case dbgClient.GoroutineSyscall:
+        return "syscall"
+    case dbgClient.GoroutineWaiting:
+        if routine.WaitReason > 0 && routine.WaitReason < int64(len(waitReasons)) {
+            return waitReasons[routine.WaitReason]
+        }
+    }
+    return ""
+}
+
+// pprof labels as {key=value, ...}, sorted so the title does not change with map order.
+func describeLabels(labels map[string]string) string {
+    pairs := []string{}
+    for key, value := range labels {
+        pairs = append(pairs, key + "=" + value)
+    }
+    sort.Strings(pairs)
+    return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// groupSize is the number of goroutines this target stands in for, 1 unless goroutines are grouped.
+// A changed title means announcing the target again, so nothing that moves on every step goes in it. The current
+// function and the wait reason go in State instead.
+func describeGoroutine(routine dbgClient.Goroutine, groupSize int, waitReasons []string) goroutineDescription {
+    parts := []string{}
+    switch {
+    case routine.StartLoc.Function != nil:
+        parts = append(parts, shortFunctionName(dbgClient.Location(routine.StartLoc)))
+    case routine.GoStatementLoc.Function != nil:
+        parts = append(parts, "started by " + shortFunctionName(dbgClient.Location(routine.GoStatementLoc)))
+    default:
+        parts = append(parts, "<Unknown>")
+    }
+    if len(routine.Labels) > 0 {
+        parts = append(parts, describeLabels(routine.Labels))
+    }
+    if groupSize > 1 {
+        parts = append(parts, fmt.Sprintf("(+%d more)", groupSize - 1))
+    }
+    title := fmt.Sprintf("%d: %s", routine.ID, strings.Join(parts, " "))
+
+    state := "in " + shortFunctionName(dbgClient.Location(routine.UserCurrentLoc))
+    if reason := waitReason(routine, waitReasons); reason != "" {
+        state += " [" + reason + "]"
+    }
+    return goroutineDescription{
+        Title: title,
+        Url: title,
+        State: state,
+    }
+}
+
+// The go runtime's names for wait reasons, read from the program once. The order of the runtime's waitReason
+// constants changes between go releases, so only the program itself knows which name goes with a number.
+func (p *proxy) waitReasonNames() []string {
+    p.waitReasonsMux.Lock()
+    defer p.waitReasonsMux.Unlock()
+    if p.waitReasons != nil {
+        return p.waitReasons
+    }
+    p.waitReasons = []string{}
+    variable, err := p.client.EvalVariable(dbgClient.EvalScope{GoroutineID: -1}, "runtime.waitReasonStrings", dbgClient.LoadConfig{
+        MaxStringLen: 64,
+        MaxArrayValues: 256,
+    })
+    if err != nil {
+        // Runtimes before go 1.11 keep the reason as a string delve does not give us, there is nothing to show then.
+        return p.waitReasons
+    }
+    for _, name := range dbgClient.Variables(variable.Children) {
+        p.waitReasons = append(p.waitReasons, name.Value)
+    }
+    return p.waitReasons
+}
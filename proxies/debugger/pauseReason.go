@@ -0,0 +1,136 @@
+package debugger
+
+import (
+    "fmt"
+    "sync/atomic"
+    "github.com/allada/gdd/dbgClient"
+    debuggerAgent "github.com/allada/gdd/protocol/debugger"
+)
+
+// Delve sets these breakpoints on its own so it can stop the debugee before it dies.
+const (
+    unrecoveredPanicBreakpoint = "unrecovered-panic"
+    fatalThrowBreakpoint = "runtime-fatal-throw"
+)
+
+// What we last asked delve to do. Used to explain why the debugee stopped when no breakpoint was hit.
+const (
+    resumeActionStart = int32(iota) // Delve stops on main.main() for us at startup.
+    resumeActionContinue
+    resumeActionStep
+    resumeActionHalt
+)
+
+type pauseReason struct {
+    Reason debuggerAgent.PausedReasonEnum
+    Data map[string]string
+    HitBreakpoints []string
+}
+
+func (r pauseReason) pausedEvent(callFrames []debuggerAgent.CallFrame) debuggerAgent.PausedEvent {
+    event := debuggerAgent.PausedEvent{
+        Reason: r.Reason,
+        CallFrames: callFrames,
+    }
+    if len(r.Data) > 0 {
+        event.Data = &r.Data
+    }
+    if len(r.HitBreakpoints) > 0 {
+        event.HitBreakpoints = &r.HitBreakpoints
+    }
+    return event
+}
+
+// Adds description to Data, unless the reason already explains itself. Data may be shared, so it is copied.
+func (r pauseReason) withDescription(description string) pauseReason {
+    if _, ok := r.Data["description"]; ok || description == "" {
+        return r
+    }
+    data := map[string]string{}
+    for key, value := range r.Data {
+        data[key] = value
+    }
+    data["description"] = description
+    r.Data = data
+    return r
+}
+
+// Works out why routineID is paused. Only the goroutine that delve stopped on gets a real reason, every other
+// goroutine is just paused along with it.
+func (p *proxy) pauseReasonForGoroutine(state *dbgClient.DebuggerState, routineID goroutineID) pauseReason {
+    for _, thread := range state.Threads {
+        if goroutineID(thread.GoroutineID) != routineID || thread.Breakpoint == nil {
+            continue
+        }
+        breakpoint := thread.Breakpoint
+        switch breakpoint.Name {
+        case unrecoveredPanicBreakpoint:
+            return pauseReason{
+                Reason: debuggerAgent.PausedReasonException,
+                Data: map[string]string{
+                    "reason": "panic",
+                    "description": fmt.Sprintf("Unrecovered panic in goroutine %d", routineID),
+                },
+            }
+        case fatalThrowBreakpoint:
+            return pauseReason{
+                Reason: debuggerAgent.PausedReasonException,
+                Data: map[string]string{
+                    "reason": "panic",
+                    "description": fmt.Sprintf("Fatal runtime error in goroutine %d", routineID),
+                },
+            }
+        }
+        // Delve has no watchpoints yet, so every other stop on a breakpoint is one the user set.
+        reason := pauseReason{
+            Reason: debuggerAgent.PausedReasonOther,
+            Data: map[string]string{
+                "reason": "breakpoint",
+            },
+        }
+        p.breakpointsMux.Lock()
+        if _, ok := p.breakpoints[breakpoint.Name]; ok {
+            reason.HitBreakpoints = []string{breakpoint.Name}
+        }
+        p.breakpointsMux.Unlock()
+        return reason
+    }
+
+    if state.CurrentThread == nil || goroutineID(state.CurrentThread.GoroutineID) != routineID {
+        return pauseReason{
+            Reason: debuggerAgent.PausedReasonOther,
+        }
+    }
+
+    switch atomic.LoadInt32(&p.resumeAction) {
+    case resumeActionStart:
+        return pauseReason{
+            Reason: debuggerAgent.PausedReasonOther,
+            Data: map[string]string{
+                "reason": "start",
+            },
+        }
+    case resumeActionStep:
+        return pauseReason{
+            Reason: debuggerAgent.PausedReasonOther,
+            Data: map[string]string{
+                "reason": "step",
+            },
+        }
+    case resumeActionHalt:
+        return pauseReason{
+            Reason: debuggerAgent.PausedReasonOther,
+            Data: map[string]string{
+                "reason": "halt",
+            },
+        }
+    }
+    // We did not ask to stop and no breakpoint was hit, so the only thing left is the OS stopping us.
+    return pauseReason{
+        Reason: debuggerAgent.PausedReasonException,
+        Data: map[string]string{
+            "reason": "signal",
+            "description": fmt.Sprintf("Signal received at %s:%d", state.CurrentThread.File, state.CurrentThread.Line),
+        },
+    }
+}
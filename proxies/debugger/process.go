@@ -0,0 +1,119 @@
+package debugger
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "sync/atomic"
+    "github.com/allada/gdd/dbgClient"
+)
+
+// Steps report the program exiting only through the error message.
+var exitedErrorPattern = regexp.MustCompile(`has exited with status (-?\d+)`)
+
+func exitedState(err error) (*dbgClient.DebuggerState, bool) {
+    match := exitedErrorPattern.FindStringSubmatch(err.Error())
+    if match == nil {
+        return nil, false
+    }
+    status, _ := strconv.Atoi(match[1])
+    return &dbgClient.DebuggerState{
+        Exited: true,
+        ExitStatus: status,
+    }, true
+}
+
+func (p *proxy) Exited() bool {
+    return atomic.LoadInt32(&p.exited) == 1
+}
+
+// Forgets every goroutine and thread, they belong to a process that is gone.
+func (p *proxy) destroyTargets() {
+    p.activeTargetsMux.Lock()
+    for routineID, target := range p.activeTargets {
+        target.Destroy()
+        delete(p.activeTargets, routineID)
+    }
+    p.activeTargetsMux.Unlock()
+
+    p.threadTargetsMux.Lock()
+    for threadID, target := range p.threadTargets {
+        target.Destroy()
+        delete(p.threadTargets, threadID)
+    }
+    p.threadTargetsMux.Unlock()
+}
+
+// The frontend stays connected after the program exits so the output can still be read and the program restarted.
+// state is nil if delve did not say how it ended.
+func (p *proxy) sendExitedState(state *dbgClient.DebuggerState) {
+    atomic.StoreInt32(&p.exited, 1)
+    atomic.StoreInt32(&p.running, 0)
+    p.runtime.SetRunning(false)
+    p.runtime.ReleaseAllObjects()
+    p.destroyTargets()
+    p.runtime.ProcessExited(state)
+}
+
+// Runs the program again from the start in the same session, for Page.reload. Breakpoints stay set.
+func (p *proxy) Restart() error {
+    if !atomic.CompareAndSwapInt32(&p.restarting, 0, 1) {
+        return fmt.Errorf("Already restarting")
+    }
+    defer atomic.StoreInt32(&p.restarting, 0)
+    if atomic.LoadInt32(&p.running) == 1 {
+        // Delve only restarts a stopped program. The pending continue or step sees the flag once delve stopped and
+        // leaves the paused state to us.
+        atomic.StoreInt32(&p.resumeAction, resumeActionHalt)
+        atomic.StoreInt32(&p.haltedForRestart, 1)
+        if _, err := p.client.Halt(); err != nil {
+            atomic.StoreInt32(&p.haltedForRestart, 0)
+            return err
+        }
+    }
+    // Waits for the pending command to return, and keeps new ones from resuming the old process meanwhile.
+    p.resumeMux.Lock()
+    defer p.resumeMux.Unlock()
+    discarded, err := p.client.Restart()
+    if err != nil {
+        return err
+    }
+    // Delve stopped on main.main() again, same as at startup.
+    atomic.StoreInt32(&p.resumeAction, resumeActionStart)
+
+    atomic.StoreInt32(&p.exited, 0)
+    // The program may have been rebuilt with another go.
+    p.waitReasonsMux.Lock()
+    p.waitReasons = nil
+    p.waitReasonsMux.Unlock()
+    p.runtime.ReleaseAllObjects()
+    p.destroyTargets()
+    p.runtime.DestroyContext()
+    p.runtime.CreateContext()
+
+    // Breakpoints on lines that are gone from the new build. The frontend still shows them, so the user has to hear.
+    p.breakpointsMux.Lock()
+    for _, breakpoint := range discarded {
+        location := "a breakpoint"
+        if breakpoint.Breakpoint != nil {
+            delete(p.breakpoints, breakpoint.Breakpoint.Name)
+            location = fmt.Sprintf("the breakpoint at %s:%d", breakpoint.Breakpoint.File, breakpoint.Breakpoint.Line)
+        }
+        p.runtime.ConsoleError(fmt.Sprintf("Restart discarded %s: %s", location, breakpoint.Reason))
+    }
+    p.breakpointsMux.Unlock()
+
+    state, err := p.client.GetState()
+    if err != nil {
+        return err
+    }
+    if state.Exited {
+        p.sendExitedState(state)
+        return nil
+    }
+    if state.SelectedGoroutine != nil {
+        p.activeGoroutineID = goroutineID(state.SelectedGoroutine.ID)
+    }
+    p.sendPauseState()
+    return nil
+}
@@ -8,6 +8,7 @@ import (
     "sync/atomic"
     "strings"
     "crypto/sha1"
+    "github.com/allada/gdd/config"
     "github.com/allada/gdd/dbgClient"
     "github.com/allada/gdd/protocol/shared"
     debuggerAgent "github.com/allada/gdd/protocol/debugger"
@@ -15,78 +16,23 @@ import (
     targetAgent "github.com/allada/gdd/protocol/target"
 )
 
-type goroutineID int
-
-type Target struct {
-    ID goroutineID
-    Proxy *proxy
-}
-
-func (t *Target) Attach(routine dbgClient.Goroutine) {
-    var name string
-    if routine.UserCurrentLoc.Function != nil {
-        parts := strings.Split(routine.UserCurrentLoc.Function.Name, ".")
-        name = " " + parts[len(parts) - 1]
-    }
-    t.Proxy.target.FireAttachedToTarget(targetAgent.AttachedToTargetEvent{
-        TargetInfo: targetAgent.TargetInfo{
-            TargetId: targetAgent.TargetID(fmt.Sprintf("%d", t.ID)),
-            Type: "node",
-            Title: "tt",
-            Url: fmt.Sprintf("%d:%s", t.ID, name),
-        },
-        WaitingForDebugger: false,
-    })
-
-    for _, file := range t.Proxy.fileList {
-        t.Proxy.agent.FireScriptParsedOnTarget(fmt.Sprintf("%d", t.ID), debuggerAgent.ScriptParsedEvent{
-            ScriptId: runtimeAgent.ScriptId(file),
-            Url: file,
-            ExecutionContextId: 1,
-        })
-    }
-}
-
-func (t *Target) Destroy() {
-    t.Proxy.target.FireDetachedFromTarget(targetAgent.DetachedFromTargetEvent{
-        TargetId: targetAgent.TargetID(fmt.Sprintf("%d", t.ID)),
-    })
-}
-
-func (t *Target) FireResumed() {
-    t.Proxy.agent.FireResumedOnTarget(fmt.Sprintf("%d", t.ID))
-}
-
-func (t *Target) FirePaused(callframes []dbgClient.Stackframe) {
-    sendFrames := []debuggerAgent.CallFrame{}
-    for index, frame := range callframes {
-        functionName := "<Unknown>"
-        if frame.Location.Function != nil {
-            functionName = frame.Location.Function.Name
-        }
-        sendFrames = append(sendFrames, debuggerAgent.CallFrame{
-            CallFrameId: debuggerAgent.CallFrameId(fmt.Sprintf("%d", index)),
-            FunctionName: functionName,
-            Location: debuggerAgent.Location{
-                ScriptId: runtimeAgent.ScriptId(frame.Location.File),
-                LineNumber: int64(frame.Location.Line - 1), // Always -1
-            },
-            ScopeChain: t.Proxy.buildScopeChain(index),
-            This: runtimeAgent.RemoteObject{
-                Type: "undefined",
-            },
-            ReturnValue: nil,
-        })
-    }
-    t.Proxy.agent.FirePausedOnTarget(fmt.Sprintf("%d", t.ID), debuggerAgent.PausedEvent{
-        Reason: "other",
-        CallFrames: sendFrames,
-    })
-}
-
 type runtimer interface{
     CreateContext()
     MakeRemoteObject(dbgClient.Variable) runtimeAgent.RemoteObject
+    MakeScopedRemoteObject(variable dbgClient.Variable, scope dbgClient.EvalScope, expression string, objectGroup string) runtimeAgent.RemoteObject
+    ReleaseAllObjects()
+    SetRunning(running bool)
+    DestroyContext()
+    ProcessExited(state *dbgClient.DebuggerState)
+    ConsoleError(message string)
+    TracepointHit(thread dbgClient.Thread)
+    LoadConfig() dbgClient.LoadConfig
+    SetObjectIdHandler(prefix string, handler func(runtimeAgent.GetPropertiesCommand))
+    SetEvalScopeHandler(handler func(targetID string) (dbgClient.EvalScope, error))
+    SetFrameSelectedHandler(handler func(goroutineID int, frame int))
+    SetCallReturnedHandler(handler func(goroutineID int))
+    SetScriptHandler(handler func(targetID string, file string))
+    EvaluateRemoteObject(scope dbgClient.EvalScope, expression string, objectGroup string, throwOnSideEffect bool) (runtimeAgent.RemoteObject, error)
 }
 
 type proxy struct {
@@ -97,10 +43,26 @@ type proxy struct {
     runtime runtimer
 
     enabled int32 // Since Go does not have atomic_flag I use int32
+    autoAttach int32 // Since Go does not have atomic_flag I use int32
+    running int32 // Since Go does not have atomic_flag I use int32
+    exited int32 // Since Go does not have atomic_flag I use int32
+    restarting int32 // Since Go does not have atomic_flag I use int32
+    haltedForRestart int32 // Set until the continue Restart() interrupted has seen it.
+    resumeMux sync.Mutex // Held by continue and step commands until they are done, Restart() waits for them.
+    showThreads int32 // Since Go does not have atomic_flag I use int32
     activeTargetsMux sync.RWMutex
     activeTargets map[goroutineID]*Target
-    fileList []string
+    goroutineFilterMux sync.RWMutex
+    goroutineFilter config.GoroutineFilter
+    waitReasonsMux sync.Mutex
+    waitReasons []string // Names of the runtime's wait reasons, nil until read from the program.
+    threadTargetsMux sync.Mutex
+    threadTargets map[int]*threadTarget
+    scripts *scriptRegistry
     activeGoroutineID goroutineID
+    selectedFramesMux sync.Mutex
+    selectedFrames map[goroutineID]int // Frame the user selected in each goroutine, until it runs again.
+    resumeAction int32 // One of the resumeAction* constants.
     breakpointsMux sync.Mutex
     breakpoints map[string]struct{}
 }
@@ -111,9 +73,13 @@ func NewProxy(conn *shared.Connection, client *dbgClient.Client) *proxy {
     return &proxy{
         agent: agent,
         target: target,
+        scripts: newScriptRegistry(agent),
         client: client,
         conn: conn,
+        autoAttach: 1,
         activeTargets: map[goroutineID]*Target{},
+        threadTargets: map[int]*threadTarget{},
+        selectedFrames: map[goroutineID]int{},
         breakpoints: map[string]struct{}{},
     }
 }
@@ -132,9 +98,15 @@ func (p *proxy) enableAndRespond(command debuggerAgent.EnableCommand) {
     p.agent.SetStepIntoHandler(p.stepIntoAndRespond)
     p.agent.SetStepOutHandler(p.stepOutAndRespond)
     p.agent.SetResumeHandler(p.continueAndRespond)
+    p.agent.SetPauseHandler(p.pauseAndRespond)
     p.agent.SetGetScriptSourceHandler(getFileAndRespond)
     p.agent.SetEvaluateOnCallFrameHandler(p.evaluateOnGoroutineAndRespond)
 
+    p.runtime.SetObjectIdHandler(stackObjectIdPrefix, p.getStackPageAndRespond)
+    p.runtime.SetEvalScopeHandler(p.evalScope)
+    p.runtime.SetFrameSelectedHandler(p.selectFrame)
+    p.runtime.SetCallReturnedHandler(p.callReturned)
+    p.runtime.SetScriptHandler(p.scripts.AnnounceFile)
     p.runtime.CreateContext()
 
     // Wait until debugger is ready.
@@ -149,29 +121,23 @@ func (p *proxy) enableAndRespond(command debuggerAgent.EnableCommand) {
         p.activeGoroutineID = goroutineID(state.SelectedGoroutine.ID)
     }
 
-    // Closure is needed here because sendPauseState does not have panic recover in it.
-    go shared.WrapFunctionForPanicRecover(p.sendPauseState, p.conn)()
-
     sources, err := p.client.ListSources()
     if err != nil {
         shared.ThrowError(err.Error())
     }
+    // Scripts go out first so the paused event can point into them.
+    p.scripts.Load(sources)
 
-    for _, source := range sources {
-        if source == "<autogenerated>" {
-            continue;
-        }
-        p.fileList = append(p.fileList, source)
-        p.agent.FireScriptParsed(debuggerAgent.ScriptParsedEvent{
-            ScriptId: runtimeAgent.ScriptId(source),
-            Url: source,
-            ExecutionContextId: 1,
-        })
-    }
+    // Closure is needed here because sendPauseState does not have panic recover in it.
+    go shared.WrapFunctionForPanicRecover(p.sendPauseState, p.conn)()
 }
 
 func (p *proxy) Start(runtime runtimer) {
     p.runtime = runtime
+    p.target.SetSetAutoAttachHandler(p.setAutoAttachAndRespond)
+    p.target.SetAttachToTargetHandler(p.attachToTargetAndRespond)
+    p.target.SetDetachFromTargetHandler(p.detachFromTargetAndRespond)
+    p.target.SetActivateTargetHandler(p.activateTargetAndRespond)
     // Wait until we are enabled.
     p.agent.SetEnableHandler(p.enableAndRespond)
 }
@@ -183,107 +149,127 @@ func buildLocation(file string, line int) debuggerAgent.Location {
     }
 }
 
-func (p *proxy) stepOverAndRespond(command debuggerAgent.StepOverCommand) {
-    if command.DestinationTargetID != "" {
-        if targetID, err := strconv.Atoi(command.DestinationTargetID); err == nil {
-            p.activeGoroutineID = goroutineID(targetID)
-        } else {
-            command.RespondWithError(shared.ErrorCodeInternalError, "Could not convert targetID to int")
-            shared.ThrowError(err.Error())
+// Makes delve's current goroutine the one behind targetID, or the active goroutine if targetID is empty. Thread
+// targets switch to the OS thread instead, which is the only way to get at threads without a goroutine.
+func (p *proxy) switchToTarget(targetID string) error {
+    if strings.HasPrefix(targetID, threadTargetIdPrefix) {
+        return p.switchToThread(targetID)
+    }
+    if targetID != "" {
+        id, err := strconv.Atoi(targetID)
+        if err != nil {
+            return fmt.Errorf("Could not convert targetID to int")
         }
+        p.activeGoroutineID = goroutineID(id)
     }
-    
     _, err := p.client.SwitchGoroutine(int(p.activeGoroutineID))
+    return err
+}
+
+func (p *proxy) stepOverAndRespond(command debuggerAgent.StepOverCommand) {
+    p.resumeMux.Lock()
+    defer p.resumeMux.Unlock()
+    err := p.switchToTarget(command.DestinationTargetID)
     if err != nil {
         command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
         shared.ThrowError(err.Error())
     }
 
+    atomic.StoreInt32(&p.resumeAction, resumeActionStep)
     p.sendResumeState()
-    _, err = p.client.Next()
-
+    state, err := p.finishStep(p.client.Next())
+    if p.haltedByRestart() {
+        command.Respond()
+        return
+    }
     if err != nil {
         command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
         shared.ThrowError(err.Error())
     }
     command.Respond()
+    if state.Exited {
+        p.sendExitedState(state)
+        return
+    }
     p.sendPauseState()
 }
 
 func (p *proxy) stepIntoAndRespond(command debuggerAgent.StepIntoCommand) {
-    if command.DestinationTargetID != "" {
-        if targetID, err := strconv.Atoi(command.DestinationTargetID); err == nil {
-            p.activeGoroutineID = goroutineID(targetID)
-        } else {
-            command.RespondWithError(shared.ErrorCodeInternalError, "Could not convert targetID to int")
-            shared.ThrowError(err.Error())
-        }
-    }
-    
-    _, err := p.client.SwitchGoroutine(int(p.activeGoroutineID))
+    p.resumeMux.Lock()
+    defer p.resumeMux.Unlock()
+    err := p.switchToTarget(command.DestinationTargetID)
     if err != nil {
         command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
         shared.ThrowError(err.Error())
     }
 
+    atomic.StoreInt32(&p.resumeAction, resumeActionStep)
     p.sendResumeState()
-    _, err = p.client.Step()
+    state, err := p.finishStep(p.client.Step())
+    if p.haltedByRestart() {
+        command.Respond()
+        return
+    }
     if err != nil {
         command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
         shared.ThrowError(err.Error())
     }
     command.Respond()
+    if state.Exited {
+        p.sendExitedState(state)
+        return
+    }
     p.sendPauseState()
 }
 
 func (p *proxy) stepOutAndRespond(command debuggerAgent.StepOutCommand) {
-    if command.DestinationTargetID != "" {
-        if targetID, err := strconv.Atoi(command.DestinationTargetID); err == nil {
-            p.activeGoroutineID = goroutineID(targetID)
-        } else {
-            command.RespondWithError(shared.ErrorCodeInternalError, "Could not convert targetID to int")
-            shared.ThrowError(err.Error())
-        }
-    }
-    
-    _, err := p.client.SwitchGoroutine(int(p.activeGoroutineID))
+    p.resumeMux.Lock()
+    defer p.resumeMux.Unlock()
+    err := p.switchToTarget(command.DestinationTargetID)
     if err != nil {
         command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
         shared.ThrowError(err.Error())
     }
 
+    atomic.StoreInt32(&p.resumeAction, resumeActionStep)
     p.sendResumeState()
-    _, err = p.client.StepOut()
+    state, err := p.finishStep(p.client.StepOut())
+    if p.haltedByRestart() {
+        command.Respond()
+        return
+    }
     if err != nil {
         command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
         shared.ThrowError(err.Error())
     }
     command.Respond()
+    if state.Exited {
+        p.sendExitedState(state)
+        return
+    }
     p.sendPauseState()
 }
 
 func (p *proxy) continueAndRespond(command debuggerAgent.ResumeCommand) {
-    if command.DestinationTargetID != "" {
-        if targetID, err := strconv.Atoi(command.DestinationTargetID); err == nil {
-            p.activeGoroutineID = goroutineID(targetID)
-        } else {
-            command.RespondWithError(shared.ErrorCodeInternalError, "Could not convert targetID to int")
-            shared.ThrowError(err.Error())
-        }
-    }
-    
-    _, err := p.client.SwitchGoroutine(int(p.activeGoroutineID))
+    p.resumeMux.Lock()
+    defer p.resumeMux.Unlock()
+    err := p.switchToTarget(command.DestinationTargetID)
     if err != nil {
         command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
         shared.ThrowError(err.Error())
     }
     command.Respond()
 
+    atomic.StoreInt32(&p.resumeAction, resumeActionContinue)
     p.sendResumeState()
-    state, ok := <-p.client.Continue()
+    state, ok := p.continueToStop()
 
-    if !ok {
-        shared.ThrowError("It appears program has exited");
+    if p.haltedByRestart() {
+        return
+    }
+    if !ok || state.Exited {
+        p.sendExitedState(state)
+        return
     }
     if state != nil && state.SelectedGoroutine != nil {
         p.activeGoroutineID = goroutineID(state.SelectedGoroutine.ID)
@@ -291,13 +277,35 @@ func (p *proxy) continueAndRespond(command debuggerAgent.ResumeCommand) {
     p.sendPauseState()
 }
 
+// Whether Restart() halted the program under a continue or step. Restart() sends the state of the new process
+// itself. Clears the flag, so it is only true once.
+func (p *proxy) haltedByRestart() bool {
+    return atomic.CompareAndSwapInt32(&p.haltedForRestart, 1, 0)
+}
+
+func (p *proxy) pauseAndRespond(command debuggerAgent.PauseCommand) {
+    atomic.StoreInt32(&p.resumeAction, resumeActionHalt)
+    // The pending continueAndRespond() will send the pause state once delve stops.
+    if _, err := p.client.Halt(); err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    command.Respond()
+}
 
 func (p *proxy) sendResumeState() {
+    atomic.StoreInt32(&p.running, 1)
+    p.runtime.SetRunning(true)
+    p.runtime.ReleaseAllObjects()
+    p.selectedFramesMux.Lock()
+    p.selectedFrames = map[goroutineID]int{}
+    p.selectedFramesMux.Unlock()
     p.activeTargetsMux.RLock()
     defer p.activeTargetsMux.RUnlock()
     for _, target := range p.activeTargets {
         target.FireResumed()
     }
+    p.sendThreadsResumeState()
     p.agent.FireResumed()
 }
 
@@ -311,75 +319,139 @@ func (p *proxy) sendPauseState() {
     if state == nil {
         shared.ThrowError("Called sendPauseState() but not paused.")
     }
+    atomic.StoreInt32(&p.running, 0)
+    p.runtime.SetRunning(false)
 
     p.activeTargetsMux.RLock()
-
-    var activeStack *[]dbgClient.Stackframe
-    targetsStacks := map[*Target][]dbgClient.Stackframe{}
+    defer p.activeTargetsMux.RUnlock()
     for routineID, target := range p.activeTargets {
-        stacks, err := p.client.Stacktrace(int(routineID), 50, &dbgClient.LoadConfig{
-            FollowPointers: true,
-            MaxVariableRecurse: 1,
-            MaxStringLen: 1,
-            MaxArrayValues: 1,
-            MaxStructFields: 1,
-        })
-        if err != nil {
-            // TODO Something better here.
-            shared.ThrowError(err.Error())
-        }
+        // Only the goroutine the user is looking at gets its stack right away, the rest wait until asked for.
+        depth := 0
         if routineID == p.activeGoroutineID {
-            activeStack = &stacks
-            continue
+            depth = stackPageSize
+        }
+        target.Paused(p.pauseReasonForGoroutine(state, routineID), depth)
+    }
+    if p.ShowThreads() {
+        p.syncThreads(state)
+    }
+}
+
+// An empty targetID means the goroutine the user is currently looking at.
+func (p *proxy) getTarget(targetID string) (*Target, error) {
+    routineID := p.activeGoroutineID
+    if targetID != "" {
+        id, err := strconv.Atoi(targetID)
+        if err != nil {
+            return nil, err
         }
-        targetsStacks[target] = stacks
+        routineID = goroutineID(id)
+    }
+    p.activeTargetsMux.RLock()
+    defer p.activeTargetsMux.RUnlock()
+    target, ok := p.activeTargets[routineID]
+    if !ok {
+        return nil, fmt.Errorf("Goroutine %d not found", routineID)
     }
+    return target, nil
+}
 
+func (p *proxy) getStackPageAndRespond(command runtimeAgent.GetPropertiesCommand) {
+    target, err := p.getTarget(command.DestinationTargetID)
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInvalidParams, err.Error())
+        return
+    }
+    depth, err := strconv.Atoi(strings.TrimPrefix(string(command.ObjectId), stackObjectIdPrefix))
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInvalidParams, err.Error())
+        return
+    }
+    // The new paused event makes the frontend ask again for the real scope.
+    command.Respond(&runtimeAgent.GetPropertiesReturn{
+        Result: []runtimeAgent.PropertyDescriptor{},
+    })
+    target.LoadStack(depth)
+}
+
+func (p *proxy) setAutoAttachAndRespond(command targetAgent.SetAutoAttachCommand) {
+    command.Respond()
+    if !command.AutoAttach {
+        atomic.StoreInt32(&p.autoAttach, 0)
+        return
+    }
+    atomic.StoreInt32(&p.autoAttach, 1)
+    p.activeTargetsMux.RLock()
+    for _, target := range p.activeTargets {
+        target.Attach()
+    }
     p.activeTargetsMux.RUnlock()
+    p.threadTargetsMux.Lock()
+    defer p.threadTargetsMux.Unlock()
+    for _, target := range p.threadTargets {
+        target.Attach()
+        target.firePaused()
+    }
+}
 
-    if activeStack != nil {
-        // TODO move this code.
-        sendFrames := []debuggerAgent.CallFrame{}
-        for index, frame := range *activeStack {
-            functionName := "<Unknown>"
-            if frame.Location.Function != nil {
-                functionName = frame.Location.Function.Name
-            }
-            sendFrames = append(sendFrames, debuggerAgent.CallFrame{
-                CallFrameId: debuggerAgent.CallFrameId(fmt.Sprintf("%d", index)),
-                FunctionName: functionName,
-                Location: debuggerAgent.Location{
-                    ScriptId: runtimeAgent.ScriptId(frame.Location.File),
-                    LineNumber: int64(frame.Location.Line - 1), // Always -1
-                },
-                ScopeChain: p.buildScopeChain(index),
-                This: runtimeAgent.RemoteObject{
-                    Type: "undefined",
-                },
-                ReturnValue: nil,
-            })
+func (p *proxy) attachToTargetAndRespond(command targetAgent.AttachToTargetCommand) {
+    if strings.HasPrefix(string(command.TargetId), threadTargetIdPrefix) {
+        p.threadTargetsMux.Lock()
+        defer p.threadTargetsMux.Unlock()
+        target, err := p.getThreadTarget(string(command.TargetId))
+        command.Respond(&targetAgent.AttachToTargetReturn{
+            Success: err == nil,
+        })
+        if err == nil {
+            target.Attach()
+            target.firePaused()
         }
-        p.agent.FirePaused(debuggerAgent.PausedEvent{
-            Reason: "other",
-            CallFrames: sendFrames,
+        return
+    }
+    target, err := p.getTarget(string(command.TargetId))
+    if err != nil {
+        command.Respond(&targetAgent.AttachToTargetReturn{
+            Success: false,
         })
+        return
     }
+    target.Attach()
+    command.Respond(&targetAgent.AttachToTargetReturn{
+        Success: true,
+    })
+    target.LoadStack(stackPageSize)
+}
 
-    for target, stacks := range targetsStacks {
-        target.FirePaused(stacks)
+func (p *proxy) detachFromTargetAndRespond(command targetAgent.DetachFromTargetCommand) {
+    if strings.HasPrefix(string(command.TargetId), threadTargetIdPrefix) {
+        p.threadTargetsMux.Lock()
+        if target, err := p.getThreadTarget(string(command.TargetId)); err == nil {
+            target.Detach()
+        }
+        p.threadTargetsMux.Unlock()
+        command.Respond()
+        return
+    }
+    if target, err := p.getTarget(string(command.TargetId)); err == nil {
+        target.Detach()
     }
+    command.Respond()
 }
 
-func (p *proxy) buildScopeChain(frameId int) []debuggerAgent.Scope {
-    objectId := runtimeAgent.RemoteObjectId(fmt.Sprintf("local:%d", frameId))
-    return []debuggerAgent.Scope{
-        debuggerAgent.Scope{
-            Type: debuggerAgent.ScopeTypeLocal,
-            Object: runtimeAgent.RemoteObject{
-                Type: runtimeAgent.RemoteObjectTypeObject,
-                ObjectId: &objectId,
-            },
-        },
+func (p *proxy) GoroutineFilter() config.GoroutineFilter {
+    p.goroutineFilterMux.RLock()
+    defer p.goroutineFilterMux.RUnlock()
+    return p.goroutineFilter
+}
+
+// Changes which goroutines are shown as threads. If we are paused the thread list is updated right away.
+func (p *proxy) SetGoroutineFilter(filter config.GoroutineFilter) {
+    p.goroutineFilterMux.Lock()
+    p.goroutineFilter = filter
+    p.goroutineFilterMux.Unlock()
+
+    if atomic.LoadInt32(&p.enabled) == 1 && atomic.LoadInt32(&p.running) == 0 {
+        go shared.WrapFunctionForPanicRecover(p.sendPauseState, p.conn)()
     }
 }
 
@@ -390,18 +462,57 @@ func (p *proxy) syncGoroutines() {
     if err != nil {
         shared.ThrowError(err.Error())
     }
-    foundTargets := map[goroutineID]struct{}{}
+    filter := p.GoroutineFilter()
+    waitReasons := p.waitReasonNames()
+
+    // When grouping, one goroutine stands in for everything started by the same 'go' statement.
+    groupSizes := map[string]int{}
+    groupOwners := map[string]goroutineID{}
+    visibleRoutines := []*dbgClient.Goroutine{}
     for _, routine := range routines {
         id := goroutineID(routine.ID)
+        // The goroutine we are stopped on is always shown, otherwise the user could not step it.
+        if id != p.activeGoroutineID && filterHidesGoroutine(filter, *routine) {
+            continue
+        }
+        visibleRoutines = append(visibleRoutines, routine)
+        if filter.GroupByStart {
+            key := goroutineGroupKey(*routine)
+            groupSizes[key]++
+            if _, ok := groupOwners[key]; !ok || id == p.activeGoroutineID {
+                groupOwners[key] = id
+            }
+        }
+    }
+
+    foundTargets := map[goroutineID]struct{}{}
+    for _, routine := range visibleRoutines {
+        id := goroutineID(routine.ID)
+        groupSize := 1
+        if filter.GroupByStart {
+            key := goroutineGroupKey(*routine)
+            if groupOwners[key] != id {
+                continue
+            }
+            groupSize = groupSizes[key]
+        }
         foundTargets[id] = struct{}{}
-        _, ok := p.activeTargets[id]
+        description := describeGoroutine(*routine, groupSize, waitReasons)
+        target, ok := p.activeTargets[id]
         if !ok {
-            target := &Target{
+            target = &Target{
                 ID: id,
                 Proxy: p,
             }
-            target.Attach(*routine)
+            target.setRoutine(*routine, description)
+            target.Announce()
             p.activeTargets[id] = target
+            continue
+        }
+        if target.setRoutine(*routine, description) {
+            // Targets cannot be renamed, so we announce it again under its new name.
+            target.Destroy()
+            target.Announce()
         }
     }
     for routineID, target := range p.activeTargets {
@@ -479,30 +590,79 @@ func (p *proxy) removeBreakpointAndRespond(command debuggerAgent.RemoveBreakpoin
     command.Respond()
 }
 
+// Goroutine that expressions sent to targetID are evaluated in.
+func (p *proxy) targetGoroutine(targetID string) (int, error) {
+    if strings.HasPrefix(targetID, threadTargetIdPrefix) {
+        return int(p.threadGoroutine(targetID)), nil
+    }
+    if targetID == "" {
+        return int(p.activeGoroutineID), nil
+    }
+    goroutineID, err := strconv.Atoi(targetID)
+    if err != nil {
+        return 0, fmt.Errorf("Could not convert targetID to int")
+    }
+    return goroutineID, nil
+}
+
+// A function call from the console ran the program. Delve is on the goroutine of the call now, so that becomes the
+// active one, and the frontend needs fresh stacks since everything may have moved.
+func (p *proxy) callReturned(routineID int) {
+    p.activeGoroutineID = goroutineID(routineID)
+    p.sendPauseState()
+}
+
+func (p *proxy) selectFrame(routineID int, frame int) {
+    p.selectedFramesMux.Lock()
+    defer p.selectedFramesMux.Unlock()
+    p.selectedFrames[goroutineID(routineID)] = frame
+}
+
+// Scope the console of targetID evaluates in, the frame selected in its goroutine. Only valid while paused.
+func (p *proxy) evalScope(targetID string) (dbgClient.EvalScope, error) {
+    if p.Exited() {
+        return dbgClient.EvalScope{}, fmt.Errorf("The program has exited. Reload to run it again.")
+    }
+    if atomic.LoadInt32(&p.running) == 1 {
+        return dbgClient.EvalScope{}, fmt.Errorf("The program is running. Pause it to evaluate expressions.")
+    }
+    goroutineID, err := p.targetGoroutine(targetID)
+    if err != nil {
+        return dbgClient.EvalScope{}, err
+    }
+    return dbgClient.EvalScope{
+        GoroutineID: goroutineID,
+        Frame: p.selectedFrame(goroutineID),
+    }, nil
+}
+
+func (p *proxy) selectedFrame(routineID int) int {
+    p.selectedFramesMux.Lock()
+    defer p.selectedFramesMux.Unlock()
+    return p.selectedFrames[goroutineID(routineID)]
+}
+
 func (p *proxy) evaluateOnGoroutineAndRespond(command debuggerAgent.EvaluateOnCallFrameCommand) {
-    goroutineID := int(p.activeGoroutineID)
-    if command.DestinationTargetID != "" {
-        if targetID, err := strconv.Atoi(command.DestinationTargetID); err == nil {
-            goroutineID = targetID
-        } else {
-            command.RespondWithError(shared.ErrorCodeInternalError, "Could not convert targetID to int")
-            shared.ThrowError(err.Error())
-        }
+    goroutineID, err := p.targetGoroutine(command.DestinationTargetID)
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        shared.ThrowError(err.Error())
     }
     frameId, err := strconv.Atoi(string(command.CallFrameId));
     if err != nil {
         shared.ThrowError(err.Error())
     }
-    variable, err := p.client.EvalVariable(dbgClient.EvalScope{
+    scope := dbgClient.EvalScope{
         GoroutineID: goroutineID,
         Frame: frameId,
-    }, command.Expression, dbgClient.LoadConfig{
-        FollowPointers: true,
-        MaxVariableRecurse: 1,
-        MaxStringLen: 500,
-        MaxArrayValues: 1,
-        MaxStructFields: 1,
-    })
+    }
+    p.selectFrame(goroutineID, frameId)
+    objectGroup := ""
+    if command.ObjectGroup != nil {
+        objectGroup = *command.ObjectGroup
+    }
+    throwOnSideEffect := command.ThrowOnSideEffect != nil && *command.ThrowOnSideEffect
+    result, err := p.runtime.EvaluateRemoteObject(scope, command.Expression, objectGroup, throwOnSideEffect)
     if err != nil {
         fmt.Println("Error: " + err.Error())
         command.Respond(&debuggerAgent.EvaluateOnCallFrameReturn{
@@ -516,7 +676,7 @@ func (p *proxy) evaluateOnGoroutineAndRespond(command debuggerAgent.EvaluateOnCa
         return
     }
     command.Respond(&debuggerAgent.EvaluateOnCallFrameReturn{
-        Result: p.runtime.MakeRemoteObject(*variable),
+        Result: result,
     })
 }
 
@@ -0,0 +1,100 @@
+package debugger
+
+import (
+    "sync"
+    debuggerAgent "github.com/allada/gdd/protocol/debugger"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// Keeps track of which source files the frontend has been told about. The main target gets every file once,
+// goroutine and thread targets only get the files their call frames point into, the first time they need them.
+// Without this every target would get every file, which does not scale past toy programs.
+type scriptRegistry struct {
+    agent *debuggerAgent.DebuggerAgent
+
+    mux sync.Mutex
+    files map[string]struct{}
+    announced map[string]map[string]struct{} // Target id -> scripts that target knows about.
+}
+
+func newScriptRegistry(agent *debuggerAgent.DebuggerAgent) *scriptRegistry {
+    return &scriptRegistry{
+        agent: agent,
+        files: map[string]struct{}{},
+        announced: map[string]map[string]struct{}{},
+    }
+}
+
+// Registers the program's sources and announces all of them on the main target.
+func (r *scriptRegistry) Load(sources []string) {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+    for _, source := range sources {
+        if source == "<autogenerated>" {
+            continue;
+        }
+        if _, ok := r.files[source]; ok {
+            continue
+        }
+        r.files[source] = struct{}{}
+        r.agent.FireScriptParsed(buildScriptParsedEvent(source))
+    }
+}
+
+// Makes sure the target knows about every script its call frames point into.
+func (r *scriptRegistry) AnnounceFrames(targetID string, callFrames []debuggerAgent.CallFrame) {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+    announced, ok := r.announced[targetID]
+    if !ok {
+        announced = map[string]struct{}{}
+        r.announced[targetID] = announced
+    }
+    for _, callFrame := range callFrames {
+        file := string(callFrame.Location.ScriptId)
+        if _, ok := r.files[file]; !ok {
+            continue
+        }
+        if _, ok := announced[file]; ok {
+            continue
+        }
+        announced[file] = struct{}{}
+        r.agent.FireScriptParsedOnTarget(targetID, buildScriptParsedEvent(file))
+    }
+}
+
+// Makes sure file is a script the frontend of targetID knows, before something else points into it. Files delve
+// did not list are added to the main target first. An empty targetID is the main target.
+func (r *scriptRegistry) AnnounceFile(targetID string, file string) {
+    r.mux.Lock()
+    if _, ok := r.files[file]; !ok {
+        r.files[file] = struct{}{}
+        r.agent.FireScriptParsed(buildScriptParsedEvent(file))
+    }
+    r.mux.Unlock()
+    if targetID == "" {
+        return
+    }
+    r.AnnounceFrames(targetID, []debuggerAgent.CallFrame{
+        {
+            Location: debuggerAgent.Location{
+                ScriptId: runtimeAgent.ScriptId(file),
+            },
+        },
+    })
+}
+
+// Called when a target goes away, so it gets its scripts again if it comes back.
+func (r *scriptRegistry) Forget(targetID string) {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+    delete(r.announced, targetID)
+}
+
+func buildScriptParsedEvent(file string) debuggerAgent.ScriptParsedEvent {
+    return debuggerAgent.ScriptParsedEvent{
+        ScriptId: runtimeAgent.ScriptId(file),
+        Url: file,
+        ExecutionContextId: 1,
+    }
+}
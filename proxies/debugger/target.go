@@ -0,0 +1,229 @@
+package debugger
+
+import (
+    "fmt"
+    "sync"
+    "sync/atomic"
+    "github.com/allada/gdd/dbgClient"
+    debuggerAgent "github.com/allada/gdd/protocol/debugger"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+    targetAgent "github.com/allada/gdd/protocol/target"
+)
+
+// Number of frames we ask delve for at a time. Anything deeper is loaded when the frontend asks for it.
+const stackPageSize = 20
+
+const stackObjectIdPrefix = "stack:"
+
+type goroutineID int
+
+type Target struct {
+    ID goroutineID
+    Proxy *proxy
+
+    mux sync.Mutex
+    routine dbgClient.Goroutine
+    description goroutineDescription
+    attached bool
+    reason pauseReason
+    stackDepth int // 0 means we only know where the goroutine is, not how it got there.
+}
+
+func (t *Target) targetID() string {
+    return fmt.Sprintf("%d", t.ID)
+}
+
+// Returns true if the title changed and the frontend needs to hear about it.
+func (t *Target) setRoutine(routine dbgClient.Goroutine, description goroutineDescription) bool {
+    t.mux.Lock()
+    defer t.mux.Unlock()
+    t.routine = routine
+    changed := t.description.Title != description.Title || t.description.Url != description.Url
+    t.description = description
+    return changed
+}
+
+func (t *Target) targetInfo() targetAgent.TargetInfo {
+    return targetAgent.TargetInfo{
+        TargetId: targetAgent.TargetID(t.targetID()),
+        Type: "node",
+        Title: t.description.Title,
+        Url: t.description.Url,
+    }
+}
+
+// Lets the frontend know the goroutine exists. It is only attached right away if the frontend asked for auto attach.
+func (t *Target) Announce() {
+    if atomic.LoadInt32(&t.Proxy.autoAttach) == 1 {
+        t.Attach()
+        return
+    }
+    t.mux.Lock()
+    defer t.mux.Unlock()
+    t.Proxy.target.FireTargetCreated(targetAgent.TargetCreatedEvent{
+        TargetInfo: t.targetInfo(),
+    })
+}
+
+func (t *Target) Attach() {
+    t.mux.Lock()
+    defer t.mux.Unlock()
+    if t.attached {
+        return
+    }
+    t.attached = true
+    t.Proxy.target.FireAttachedToTarget(targetAgent.AttachedToTargetEvent{
+        TargetInfo: t.targetInfo(),
+        WaitingForDebugger: false,
+    })
+}
+
+func (t *Target) Detach() {
+    t.mux.Lock()
+    defer t.mux.Unlock()
+    if !t.attached {
+        return
+    }
+    t.attached = false
+    t.Proxy.scripts.Forget(t.targetID())
+    t.Proxy.target.FireDetachedFromTarget(targetAgent.DetachedFromTargetEvent{
+        TargetId: targetAgent.TargetID(t.targetID()),
+    })
+}
+
+func (t *Target) Destroy() {
+    t.mux.Lock()
+    defer t.mux.Unlock()
+    if t.attached {
+        t.attached = false
+        t.Proxy.scripts.Forget(t.targetID())
+        t.Proxy.target.FireDetachedFromTarget(targetAgent.DetachedFromTargetEvent{
+            TargetId: targetAgent.TargetID(t.targetID()),
+        })
+        return
+    }
+    t.Proxy.target.FireTargetDestroyed(targetAgent.TargetDestroyedEvent{
+        TargetId: targetAgent.TargetID(t.targetID()),
+    })
+}
+
+func (t *Target) FireResumed() {
+    t.mux.Lock()
+    defer t.mux.Unlock()
+    if !t.attached {
+        return
+    }
+    t.Proxy.agent.FireResumedOnTarget(t.targetID())
+}
+
+// Records why the goroutine stopped and sends it to the frontend. Only stackDepth frames are loaded from delve,
+// the rest are fetched with LoadStack() when the frontend wants them.
+func (t *Target) Paused(reason pauseReason, stackDepth int) {
+    t.mux.Lock()
+    defer t.mux.Unlock()
+    t.reason = reason.withDescription(t.description.State)
+    t.stackDepth = stackDepth
+    t.firePaused()
+}
+
+// Makes sure at least depth frames are loaded and resends the paused state with them.
+func (t *Target) LoadStack(depth int) {
+    t.mux.Lock()
+    defer t.mux.Unlock()
+    if depth <= t.stackDepth {
+        return
+    }
+    t.stackDepth = depth
+    t.firePaused()
+}
+
+// Must be called with t.mux held.
+func (t *Target) firePaused() {
+    isActive := t.ID == t.Proxy.activeGoroutineID
+    if !isActive && !t.attached {
+        return
+    }
+    callFrames := t.callFrames()
+    event := t.reason.pausedEvent(callFrames)
+    if isActive {
+        t.Proxy.agent.FirePaused(event)
+        return
+    }
+    t.Proxy.scripts.AnnounceFrames(t.targetID(), callFrames)
+    t.Proxy.agent.FirePausedOnTarget(t.targetID(), event)
+}
+
+// Must be called with t.mux held.
+func (t *Target) callFrames() []debuggerAgent.CallFrame {
+    if t.stackDepth > 0 {
+        // Delve gives back up to depth + 1 frames, the extra one tells us if there is more to load.
+        frames, err := t.Proxy.client.Stacktrace(int(t.ID), t.stackDepth, nil)
+        if err == nil {
+            sendFrames := []debuggerAgent.CallFrame{}
+            for index, frame := range frames {
+                if index == t.stackDepth {
+                    sendFrames = append(sendFrames, buildMoreFramesCallFrame(index, dbgClient.Location(frame.Location)))
+                    break
+                }
+                sendFrames = append(sendFrames, buildCallFrame(index, dbgClient.Location(frame.Location), buildScopeChain(index)))
+            }
+            return sendFrames
+        }
+        // Goroutine probably went away under us, just show where we last saw it.
+        fmt.Println("Error: " + err.Error())
+    }
+    return []debuggerAgent.CallFrame{
+        buildMoreFramesCallFrame(0, dbgClient.Location(t.routine.UserCurrentLoc)),
+    }
+}
+
+func buildCallFrame(index int, location dbgClient.Location, scopeChain []debuggerAgent.Scope) debuggerAgent.CallFrame {
+    functionName := "<Unknown>"
+    if location.Function != nil {
+        functionName = location.Function.Name
+    }
+    return debuggerAgent.CallFrame{
+        CallFrameId: debuggerAgent.CallFrameId(fmt.Sprintf("%d", index)),
+        FunctionName: functionName,
+        Location: debuggerAgent.Location{
+            ScriptId: runtimeAgent.ScriptId(location.File),
+            LineNumber: int64(location.Line - 1), // Always -1
+        },
+        ScopeChain: scopeChain,
+        This: runtimeAgent.RemoteObject{
+            Type: "undefined",
+        },
+        ReturnValue: nil,
+    }
+}
+
+// The frontend asks for the scope of a frame when it is selected, so we use that to know it wants a deeper stack.
+func buildMoreFramesCallFrame(index int, location dbgClient.Location) debuggerAgent.CallFrame {
+    objectId := runtimeAgent.RemoteObjectId(fmt.Sprintf("%s%d", stackObjectIdPrefix, index + stackPageSize))
+    callFrame := buildCallFrame(index, location, []debuggerAgent.Scope{
+        debuggerAgent.Scope{
+            Type: debuggerAgent.ScopeTypeLocal,
+            Object: runtimeAgent.RemoteObject{
+                Type: runtimeAgent.RemoteObjectTypeObject,
+                ObjectId: &objectId,
+            },
+        },
+    })
+    if index > 0 {
+        callFrame.FunctionName = "<Select to load more frames>"
+    }
+    return callFrame
+}
+
+func buildScopeChain(frameId int) []debuggerAgent.Scope {
+    objectId := runtimeAgent.RemoteObjectId(fmt.Sprintf("local:%d", frameId))
+    return []debuggerAgent.Scope{
+        debuggerAgent.Scope{
+            Type: debuggerAgent.ScopeTypeLocal,
+            Object: runtimeAgent.RemoteObject{
+                Type: runtimeAgent.RemoteObjectTypeObject,
+                ObjectId: &objectId,
+            },
+        },
+    }
+}
@@ -0,0 +1,284 @@
+package debugger
+
+import (
+    "fmt"
+    "io/ioutil"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+    "github.com/allada/gdd/dbgClient"
+    "github.com/allada/gdd/protocol/shared"
+    debuggerAgent "github.com/allada/gdd/protocol/debugger"
+    targetAgent "github.com/allada/gdd/protocol/target"
+)
+
+const threadTargetIdPrefix = "thread:"
+
+// Linux reports cpu time in clock ticks. This is 100 everywhere go runs, we cannot ask sysconf without cgo.
+const clockTicksPerSecond = 100
+
+// An OS thread of the debugee. These are listed next to the goroutines so cgo code, LockOSThread users and signal
+// handlers can be looked at. Guarded by p.threadTargetsMux.
+type threadTarget struct {
+    ID int
+    Proxy *proxy
+    thread dbgClient.Thread
+    attached bool
+    reason pauseReason
+}
+
+func (t *threadTarget) targetID() string {
+    return fmt.Sprintf("%s%d", threadTargetIdPrefix, t.ID)
+}
+
+// Thread ids never change, so neither does the title. Where the thread is goes in the paused event.
+func (t *threadTarget) targetInfo() targetAgent.TargetInfo {
+    title := fmt.Sprintf("Thread %d", t.ID)
+    return targetAgent.TargetInfo{
+        TargetId: targetAgent.TargetID(t.targetID()),
+        Type: "node",
+        Title: title,
+        Url: title,
+    }
+}
+
+// Same as goroutine targets, the thread is only attached right away if the frontend asked for auto attach.
+func (t *threadTarget) Announce() {
+    if atomic.LoadInt32(&t.Proxy.autoAttach) == 1 {
+        t.Attach()
+        return
+    }
+    t.Proxy.target.FireTargetCreated(targetAgent.TargetCreatedEvent{
+        TargetInfo: t.targetInfo(),
+    })
+}
+
+func (t *threadTarget) Attach() {
+    if t.attached {
+        return
+    }
+    t.attached = true
+    t.Proxy.target.FireAttachedToTarget(targetAgent.AttachedToTargetEvent{
+        TargetInfo: t.targetInfo(),
+        WaitingForDebugger: false,
+    })
+}
+
+func (t *threadTarget) Detach() {
+    if !t.attached {
+        return
+    }
+    t.attached = false
+    t.Proxy.scripts.Forget(t.targetID())
+    t.Proxy.target.FireDetachedFromTarget(targetAgent.DetachedFromTargetEvent{
+        TargetId: targetAgent.TargetID(t.targetID()),
+    })
+}
+
+func (t *threadTarget) Destroy() {
+    if t.attached {
+        t.Detach()
+        return
+    }
+    t.Proxy.target.FireTargetDestroyed(targetAgent.TargetDestroyedEvent{
+        TargetId: targetAgent.TargetID(t.targetID()),
+    })
+}
+
+func (t *threadTarget) FireResumed() {
+    if !t.attached {
+        return
+    }
+    t.Proxy.agent.FireResumedOnTarget(t.targetID())
+}
+
+func (t *threadTarget) Paused(reason pauseReason) {
+    t.reason = reason
+    t.firePaused()
+}
+
+// Also used to catch up a thread that was attached while we are paused.
+func (t *threadTarget) firePaused() {
+    if !t.attached || t.reason.Reason == "" || atomic.LoadInt32(&t.Proxy.running) == 1 {
+        return
+    }
+    // Locals are looked up by goroutine, so there is no scope to show for a thread.
+    callFrame := buildCallFrame(0, dbgClient.Location{
+        PC: t.thread.PC,
+        File: t.thread.File,
+        Line: t.thread.Line,
+        Function: t.thread.Function,
+    }, []debuggerAgent.Scope{})
+    callFrames := []debuggerAgent.CallFrame{callFrame}
+    t.Proxy.scripts.AnnounceFrames(t.targetID(), callFrames)
+    t.Proxy.agent.FirePausedOnTarget(t.targetID(), t.reason.pausedEvent(callFrames))
+}
+
+// Reads user + system cpu time of a thread from /proc/<pid>/task/<tid>/stat.
+func readThreadCPUTime(pid int, tid int) (time.Duration, error) {
+    data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%d/stat", pid, tid))
+    if err != nil {
+        return 0, err
+    }
+    // The command name is in parentheses and may contain spaces, so we only split what comes after it.
+    stat := string(data)
+    fields := strings.Fields(stat[strings.LastIndex(stat, ")") + 1:])
+    // fields[0] is field 3 (state) in proc(5), utime and stime are fields 14 and 15.
+    if len(fields) < 13 {
+        return 0, fmt.Errorf("Unexpected format of thread stat file")
+    }
+    utime, err := strconv.ParseInt(fields[11], 10, 64)
+    if err != nil {
+        return 0, err
+    }
+    stime, err := strconv.ParseInt(fields[12], 10, 64)
+    if err != nil {
+        return 0, err
+    }
+    return time.Duration(utime + stime) * time.Second / clockTicksPerSecond, nil
+}
+
+// What the thread is up to right now. This changes on every pause, so it goes with the paused event.
+func describeThread(pid int, thread dbgClient.Thread) string {
+    parts := []string{
+        "no goroutine",
+    }
+    if thread.GoroutineID != 0 {
+        parts[0] = fmt.Sprintf("goroutine %d", thread.GoroutineID)
+    }
+    if cpuTime, err := readThreadCPUTime(pid, thread.ID); err == nil {
+        parts = append(parts, "cpu " + cpuTime.String())
+    }
+    return strings.Join(parts, ", ")
+}
+
+func (p *proxy) ShowThreads() bool {
+    return atomic.LoadInt32(&p.showThreads) == 1
+}
+
+// Turns listing OS threads next to the goroutines on or off. If we are paused the thread list is updated right away.
+func (p *proxy) SetShowThreads(show bool) {
+    if !show {
+        atomic.StoreInt32(&p.showThreads, 0)
+        p.threadTargetsMux.Lock()
+        defer p.threadTargetsMux.Unlock()
+        for threadID, target := range p.threadTargets {
+            target.Destroy()
+            delete(p.threadTargets, threadID)
+        }
+        return
+    }
+    atomic.StoreInt32(&p.showThreads, 1)
+    if atomic.LoadInt32(&p.enabled) == 1 && atomic.LoadInt32(&p.running) == 0 {
+        go shared.WrapFunctionForPanicRecover(p.sendPauseState, p.conn)()
+    }
+}
+
+func (p *proxy) syncThreads(state *dbgClient.DebuggerState) {
+    p.threadTargetsMux.Lock()
+    defer p.threadTargetsMux.Unlock()
+    threads, err := p.client.ListThreads()
+    if err != nil {
+        shared.ThrowError(err.Error())
+    }
+    pid := p.client.ProcessPid()
+    foundThreads := map[int]struct{}{}
+    for _, thread := range threads {
+        foundThreads[thread.ID] = struct{}{}
+        target, ok := p.threadTargets[thread.ID]
+        if !ok {
+            target = &threadTarget{
+                ID: thread.ID,
+                Proxy: p,
+            }
+            target.Announce()
+            p.threadTargets[thread.ID] = target
+        }
+        target.thread = *thread
+
+        reason := pauseReason{
+            Reason: debuggerAgent.PausedReasonOther,
+        }
+        if thread.GoroutineID != 0 {
+            reason = p.pauseReasonForGoroutine(state, goroutineID(thread.GoroutineID))
+        }
+        if _, ok := reason.Data["description"]; !ok {
+            data := map[string]string{}
+            for key, value := range reason.Data {
+                data[key] = value
+            }
+            data["description"] = describeThread(pid, *thread)
+            reason.Data = data
+        }
+        target.Paused(reason)
+    }
+    for threadID, target := range p.threadTargets {
+        if _, ok := foundThreads[threadID]; !ok {
+            target.Destroy()
+            delete(p.threadTargets, threadID)
+        }
+    }
+}
+
+func (p *proxy) sendThreadsResumeState() {
+    p.threadTargetsMux.Lock()
+    defer p.threadTargetsMux.Unlock()
+    for _, target := range p.threadTargets {
+        target.FireResumed()
+    }
+}
+
+func (p *proxy) switchToThread(targetID string) error {
+    threadID, err := strconv.Atoi(strings.TrimPrefix(targetID, threadTargetIdPrefix))
+    if err != nil {
+        return fmt.Errorf("Could not convert targetID to int")
+    }
+    state, err := p.client.SwitchThread(threadID)
+    if err != nil {
+        return err
+    }
+    if state != nil && state.SelectedGoroutine != nil {
+        p.activeGoroutineID = goroutineID(state.SelectedGoroutine.ID)
+    }
+    return nil
+}
+
+// Thread target behind targetID, for attaching and detaching. Must be called with p.threadTargetsMux held.
+func (p *proxy) getThreadTarget(targetID string) (*threadTarget, error) {
+    threadID, err := strconv.Atoi(strings.TrimPrefix(targetID, threadTargetIdPrefix))
+    if err != nil {
+        return nil, fmt.Errorf("Could not convert targetID to int")
+    }
+    target, ok := p.threadTargets[threadID]
+    if !ok {
+        return nil, fmt.Errorf("Thread %d not found", threadID)
+    }
+    return target, nil
+}
+
+// Goroutine running on the thread behind targetID, or the active goroutine if the thread has none.
+func (p *proxy) threadGoroutine(targetID string) goroutineID {
+    threadID, err := strconv.Atoi(strings.TrimPrefix(targetID, threadTargetIdPrefix))
+    if err != nil {
+        return p.activeGoroutineID
+    }
+    p.threadTargetsMux.Lock()
+    defer p.threadTargetsMux.Unlock()
+    if target, ok := p.threadTargets[threadID]; ok && target.thread.GoroutineID != 0 {
+        return goroutineID(target.thread.GoroutineID)
+    }
+    return p.activeGoroutineID
+}
+
+// Selecting a thread or goroutine in the frontend makes it the one we step and show the stack of.
+func (p *proxy) activateTargetAndRespond(command targetAgent.ActivateTargetCommand) {
+    if err := p.switchToTarget(string(command.TargetId)); err != nil {
+        command.RespondWithError(shared.ErrorCodeInvalidParams, err.Error())
+        return
+    }
+    command.Respond()
+    if atomic.LoadInt32(&p.enabled) == 1 && atomic.LoadInt32(&p.running) == 0 {
+        p.sendPauseState()
+    }
+}
@@ -0,0 +1,67 @@
+package debugger
+
+import (
+    "fmt"
+    "github.com/allada/gdd/dbgClient"
+)
+
+// Delve stops at tracepoints like at breakpoints and the client continues right away. A stop where only tracepoints
+// were hit is not one the user should see.
+func isTracepointStop(state *dbgClient.DebuggerState) bool {
+    if state == nil {
+        return false
+    }
+    hit := false
+    for _, thread := range state.Threads {
+        if thread.Breakpoint == nil {
+            continue
+        }
+        if !thread.Breakpoint.Tracepoint {
+            return false
+        }
+        hit = true
+    }
+    return hit
+}
+
+func (p *proxy) reportTracepoints(state *dbgClient.DebuggerState) {
+    if state == nil {
+        return
+    }
+    for _, thread := range state.Threads {
+        if thread.Breakpoint != nil && thread.Breakpoint.Tracepoint {
+            p.runtime.TracepointHit(dbgClient.Thread(*thread))
+        }
+    }
+}
+
+// Continues until the program really stops, passing on every tracepoint on the way.
+func (p *proxy) continueToStop() (*dbgClient.DebuggerState, bool) {
+    return p.client.ContinueToStop(p.reportTracepoints)
+}
+
+// Steps get interrupted by tracepoints too, continuing finishes the step. Also turns delve's error for a program that
+// exited while stepping into an exited state.
+func (p *proxy) finishStep(state *dbgClient.DebuggerState, err error) (*dbgClient.DebuggerState, error) {
+    if err != nil {
+        if exited, ok := exitedState(err); ok {
+            return exited, nil
+        }
+        return nil, err
+    }
+    for isTracepointStop(state) && state.NextInProgress {
+        p.reportTracepoints(state)
+        next, ok := p.continueToStop()
+        if !ok {
+            return nil, fmt.Errorf("Lost the program while finishing the step")
+        }
+        state = next
+    }
+    if state.Exited {
+        return state, nil
+    }
+    if state.Err != nil {
+        return nil, state.Err
+    }
+    return state, nil
+}
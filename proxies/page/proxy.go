@@ -1,6 +1,7 @@
 package page
 
 import (
+    "sync"
     "github.com/allada/gdd/dbgClient"
     "github.com/allada/gdd/protocol/shared"
     pageAgent "github.com/allada/gdd/protocol/page"
@@ -9,6 +10,8 @@ import (
 type proxy struct {
     agent *pageAgent.PageAgent
     client *dbgClient.Client
+    restartHandlerMux sync.RWMutex
+    restartHandler func() error
 }
 
 func NewProxy(conn *shared.Connection, client *dbgClient.Client) *proxy {
@@ -32,6 +35,29 @@ func (p *proxy) enableAndRespond(command pageAgent.EnableCommand) {
     command.Respond()
 
     p.agent.SetGetResourceTreeHandler(p.getResourceTreeAndRespond)
+    p.agent.SetReloadHandler(p.reloadAndRespond)
+}
+
+// Reloading the page restarts the program, the debugger knows how.
+func (p *proxy) SetRestartHandler(handler func() error) {
+    p.restartHandlerMux.Lock()
+    defer p.restartHandlerMux.Unlock()
+    p.restartHandler = handler
+}
+
+func (p *proxy) reloadAndRespond(command pageAgent.ReloadCommand) {
+    p.restartHandlerMux.RLock()
+    handler := p.restartHandler
+    p.restartHandlerMux.RUnlock()
+    if handler == nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, "The debugger is not enabled yet.")
+        return
+    }
+    if err := handler(); err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    command.Respond()
 }
 
 func (p *proxy) getResourceTreeAndRespond(command pageAgent.GetResourceTreeCommand) {
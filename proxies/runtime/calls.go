@@ -0,0 +1,177 @@
+package runtime
+
+import (
+    "fmt"
+    "reflect"
+    "regexp"
+    "strings"
+    "github.com/allada/gdd/dbgClient"
+    "github.com/allada/gdd/protocol/shared"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// Object groups devtools uses for hover previews and watch expressions. These are evaluated all the time without
+// the user asking, so they must never run code in the program.
+var sideEffectFreeObjectGroups = map[string]struct{}{
+    "popover": struct{}{},
+    "watch-group": struct{}{},
+}
+
+// The only js functions we can "call" on a go value: function() { return <go expression using this>; }
+var callFunctionOnPattern = regexp.MustCompile(`(?s)^\s*function\s*\w*\s*\(\s*\)\s*\{\s*return\s+(.+?);?\s*\}\s*$`)
+var thisPattern = regexp.MustCompile(`\bthis\b`)
+
+// What delve answers when an expression has a call in it, it only runs calls through its call command.
+const functionCallNotAllowedError = "function calls not allowed without using 'call'"
+
+func isFunctionCallError(err error) bool {
+    return err.Error() == functionCallNotAllowedError
+}
+
+// Runs expression, which contains a function call, in the goroutine of scope by injecting the call with delve.
+func (p *proxy) callFunction(scope dbgClient.EvalScope, expression string) (*dbgClient.Variable, *objectRef, error) {
+    if scope.Frame != 0 {
+        return nil, nil, fmt.Errorf("Functions can only be called from the topmost frame.")
+    }
+    if _, err := p.client.SwitchGoroutine(scope.GoroutineID); err != nil {
+        return nil, nil, err
+    }
+    state, err := p.client.Call(expression, false)
+    // The program ran and delve is on the goroutine of the call now. Nothing the frontend holds is valid anymore,
+    // and the debugger has to send where everything is again.
+    p.ReleaseAllObjects()
+    p.callReturned(scope.GoroutineID)
+    if err != nil {
+        return nil, nil, err
+    }
+    if state.CurrentThread == nil {
+        return nil, nil, fmt.Errorf("The call did not return")
+    }
+    results := dbgClient.Variables(state.CurrentThread.ReturnValues)
+    switch len(results) {
+    case 0:
+        return &dbgClient.Variable{Kind: reflect.Invalid}, nil, nil
+    case 1:
+        result := results[0]
+        // Results live in the frame of the call which is gone by now. Only what they point at can be loaded again.
+        if result.Kind == reflect.Ptr && isComposite(result) {
+            return &result, &objectRef{
+                Scope: scope,
+                Expression: fmt.Sprintf("(%s)(%#x)", typeExpression(result.Type), pointerValue(result)),
+            }, nil
+        }
+        return &result, nil, nil
+    }
+    types := []string{}
+    for _, result := range results {
+        types = append(types, result.Type)
+    }
+    // Multiple results are shown as one struct of all of them.
+    tuple := dbgClient.Variable{
+        Kind: reflect.Struct,
+        Type: "(" + strings.Join(types, ", ") + ")",
+        Len: int64(len(results)),
+    }
+    tuple.Children = state.CurrentThread.ReturnValues
+    return &tuple, nil, nil
+}
+
+// Evaluates expression in scope, calling functions if it has to and neither the object group nor throwOnSideEffect
+// forbid it. The returned ref is nil if the value cannot be loaded again.
+func (p *proxy) evaluate(scope dbgClient.EvalScope, expression string, objectGroup string, throwOnSideEffect bool, cfg dbgClient.LoadConfig) (*dbgClient.Variable, *objectRef, error) {
+    expression, err := p.expandTemps(expression)
+    if err != nil {
+        return nil, nil, err
+    }
+    variable, err := p.client.EvalVariable(scope, expression, cfg)
+    if err == nil {
+        // Eager previews evaluate on every keystroke, those are not results.
+        if objectGroup == "console" && !throwOnSideEffect {
+            p.rememberResult(*variable)
+        }
+        return variable, &objectRef{
+            Scope: scope,
+            Expression: expression,
+            Group: objectGroup,
+        }, nil
+    }
+    if !isFunctionCallError(err) {
+        return nil, nil, err
+    }
+    if _, ok := sideEffectFreeObjectGroups[objectGroup]; ok || throwOnSideEffect {
+        return nil, nil, fmt.Errorf("Functions are not called in hover, watch and eager expressions, since they could change the program.")
+    }
+    variable, ref, err := p.callFunction(scope, expression)
+    if ref != nil {
+        ref.Group = objectGroup
+    }
+    if err == nil && objectGroup == "console" {
+        p.rememberResult(*variable)
+    }
+    return variable, ref, err
+}
+
+// Same as Runtime.evaluate, for the debugger's evaluateOnCallFrame.
+func (p *proxy) EvaluateRemoteObject(scope dbgClient.EvalScope, expression string, objectGroup string, throwOnSideEffect bool) (runtimeAgent.RemoteObject, error) {
+    if globalThisPattern.MatchString(expression) {
+        return p.globalObject(), nil
+    }
+    // The console evaluates on the selected call frame while paused, so its commands come this way too.
+    if asJSON, argument, ok := parseCopyCommand(expression); ok {
+        if throwOnSideEffect {
+            return runtimeAgent.RemoteObject{}, fmt.Errorf("copy() is not run in previews, it writes the clipboard.")
+        }
+        return undefinedObject(), p.copyCommand(scope, asJSON, argument)
+    }
+    if result, ok, err := p.consoleCommand(expression, throwOnSideEffect, func() (dbgClient.EvalScope, error) { return scope, nil }); ok {
+        return result, err
+    }
+    variable, ref, err := p.evaluate(scope, expression, objectGroup, throwOnSideEffect, p.LoadConfig())
+    if err != nil {
+        return runtimeAgent.RemoteObject{}, err
+    }
+    return p.makeRemoteObject(*variable, ref), nil
+}
+
+func (p *proxy) callFunctionOnAndRespond(command runtimeAgent.CallFunctionOnCommand) {
+    if command.ObjectId == globalObjectId {
+        p.saveVariableAndRespond(command)
+        return
+    }
+    ref, ok := p.objects.Get(command.ObjectId)
+    if !ok {
+        command.RespondWithError(shared.ErrorCodeInvalidParams, "Could not find object with given id")
+        return
+    }
+    if copyFunctionPattern.MatchString(command.FunctionDeclaration) && !ref.IsRange {
+        p.copyObjectAndRespond(command, ref)
+        return
+    }
+    match := callFunctionOnPattern.FindStringSubmatch(command.FunctionDeclaration)
+    if match == nil || ref.IsRange || (command.Arguments != nil && len(*command.Arguments) > 0) {
+        command.RespondWithError(shared.ErrorCodeInvalidParams, "Only functions like 'function() { return this.Method(); }' can be called on go values")
+        return
+    }
+    expression := thisPattern.ReplaceAllLiteralString(match[1], parenthesize(ref.Expression))
+    returnByValue := command.ReturnByValue != nil && *command.ReturnByValue
+    cfg := p.LoadConfig()
+    if returnByValue {
+        cfg.MaxVariableRecurse = returnByValueRecurse
+    }
+    variable, resultRef, err := p.evaluate(ref.Scope, expression, ref.Group, false, cfg)
+    if err != nil {
+        details := exceptionDetails(err)
+        command.Respond(&runtimeAgent.CallFunctionOnReturn{
+            Result: *details.Exception,
+            ExceptionDetails: details,
+        })
+        return
+    }
+    result := p.makeRemoteObject(*variable, resultRef)
+    if returnByValue {
+        result = p.valueRemoteObject(*variable)
+    }
+    command.Respond(&runtimeAgent.CallFunctionOnReturn{
+        Result: result,
+    })
+}
@@ -0,0 +1,247 @@
+package runtime
+
+import (
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "github.com/allada/gdd/dbgClient"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// fmt.Print*, the log package and slog's default handlers all end up writing to os.Stdout or os.Stderr through
+// this one method, so one tracepoint sees every line they print.
+const outputTracepointFunction = "os.(*File).Write"
+const outputTracepointName = "gddOutputCallsite"
+
+// Frames delve collects per write. Enough to get out of fmt and log into the code that called them.
+const callsiteStackDepth = 20
+
+// Bytes of each write we keep to match it with the line read from the pipe.
+const callsiteMaxBytes = 4096
+
+// Writes we remember per stream, and how many are looked at for each line. Writes that never show up as a line
+// (output of other files, lost lines) fall off the end.
+const maxPendingWrites = 1000
+const callsiteLookahead = 16
+
+// What the program is about to write to stdout or stderr, and from where.
+type pendingWrite struct {
+    Remaining string // Part of the write no line was read for yet.
+    Truncated bool // There was more than we loaded, Remaining is only the start of it.
+    StackTrace *runtimeAgent.StackTrace
+}
+
+type callsiteQueue struct {
+    mux sync.Mutex
+    writes map[int][]*pendingWrite // Fd -> writes in order.
+}
+
+func newCallsiteQueue() *callsiteQueue {
+    return &callsiteQueue{
+        writes: map[int][]*pendingWrite{},
+    }
+}
+
+func (q *callsiteQueue) Add(fd int, write *pendingWrite) {
+    q.mux.Lock()
+    defer q.mux.Unlock()
+    writes := append(q.writes[fd], write)
+    if len(writes) > maxPendingWrites {
+        writes = writes[len(writes) - maxPendingWrites:]
+    }
+    q.writes[fd] = writes
+}
+
+// Finds the writes line was made of, starting at writes[0]. A line can take several writes (fmt.Print("a") then
+// fmt.Println("b")) and one write can be several lines. Returns how many writes are used up and what is left of the
+// next one.
+func matchWrites(writes []*pendingWrite, line string) (int, string, bool) {
+    for i, write := range writes {
+        if strings.HasPrefix(write.Remaining, line) {
+            rest := write.Remaining[len(line):]
+            switch {
+            case rest == "":
+                return i + 1, "", true
+            case strings.HasPrefix(rest, "\n"), strings.HasPrefix(rest, "\r\n"):
+                rest = strings.TrimPrefix(strings.TrimPrefix(rest, "\r"), "\n")
+                if rest == "" {
+                    return i + 1, "", true
+                }
+                return i, rest, true
+            }
+            return 0, "", false
+        }
+        if !strings.HasPrefix(line, write.Remaining) {
+            return 0, "", false
+        }
+        if write.Truncated {
+            // We cannot tell where the rest of it ends, so it ends here.
+            return i + 1, "", true
+        }
+        line = line[len(write.Remaining):]
+    }
+    return 0, "", false
+}
+
+// Stack of the write that printed line, nil if there was none.
+func (q *callsiteQueue) Take(fd int, line string) *runtimeAgent.StackTrace {
+    q.mux.Lock()
+    defer q.mux.Unlock()
+    writes := q.writes[fd]
+    for start := 0; start < len(writes) && start < callsiteLookahead; start++ {
+        used, rest, ok := matchWrites(writes[start:], line)
+        if !ok {
+            continue
+        }
+        stackTrace := writes[start].StackTrace
+        // Anything skipped over was written somewhere we never saw.
+        writes = writes[start + used:]
+        if rest != "" {
+            writes[0].Remaining = rest
+        }
+        q.writes[fd] = writes
+        return stackTrace
+    }
+    return nil
+}
+
+func (p *proxy) Callsites() bool {
+    return atomic.LoadInt32(&p.callsites) == 1
+}
+
+// Callsites makes every line the program prints link to the code that printed it. It costs a trip through delve
+// for every write to a file, so it is off unless asked for.
+func (p *proxy) SetCallsites(callsites bool) {
+    if callsites {
+        atomic.StoreInt32(&p.callsites, 1)
+    } else {
+        atomic.StoreInt32(&p.callsites, 0)
+    }
+}
+
+func (p *proxy) installOutputTracepoint() {
+    // Start() clears every breakpoint once it reaches main, ours has to come after that.
+    p.client.BlockUntilReady()
+    cfg := dbgClient.LoadConfig{
+        FollowPointers: true,
+        MaxVariableRecurse: 3,
+        MaxStringLen: callsiteMaxBytes,
+        MaxArrayValues: callsiteMaxBytes,
+        MaxStructFields: -1,
+    }
+    _, err := p.client.CreateTracepoint(outputTracepointFunction, outputTracepointName, callsiteStackDepth, cfg)
+    if err != nil {
+        fmt.Println("Could not trace output callsites: " + err.Error())
+    }
+}
+
+func deref(variable dbgClient.Variable) dbgClient.Variable {
+    if variable.Kind == reflect.Ptr && len(variable.Children) > 0 {
+        return dbgClient.Variable(variable.Children[0])
+    }
+    return variable
+}
+
+// Only stdout and stderr come back to us. os.Stdout and os.Stderr are the files named like this.
+func outputFd(file dbgClient.Variable) (int, bool) {
+    inner, ok := childByName(deref(file), "file")
+    if !ok {
+        return 0, false
+    }
+    name, ok := childByName(deref(inner), "name")
+    if !ok {
+        return 0, false
+    }
+    switch name.Value {
+    case "/dev/stdout":
+        return 1, true
+    case "/dev/stderr":
+        return 2, true
+    }
+    return 0, false
+}
+
+// Like byteValues, but takes what was loaded of a longer slice too.
+func loadedBytes(variable dbgClient.Variable) ([]byte, bool) {
+    elements := children(variable)
+    data := make([]byte, 0, len(elements))
+    for _, element := range elements {
+        value, err := strconv.ParseUint(element.Value, 10, 8)
+        if err != nil {
+            break
+        }
+        data = append(data, byte(value))
+    }
+    return data, int64(len(data)) < variable.Len
+}
+
+// Stack of a write starting at the code outside of GOROOT that caused it, fmt and os are not interesting.
+func callsiteStackTrace(goroutineID int, frames []dbgClient.Stackframe) *runtimeAgent.StackTrace {
+    start := 0
+    for start < len(frames) && dbgClient.IsGorootFile(frames[start].File) {
+        start++
+    }
+    if start == len(frames) {
+        start = 0
+    }
+    callFrames := []runtimeAgent.CallFrame{}
+    for _, frame := range frames[start:] {
+        functionName := ""
+        if frame.Function != nil {
+            functionName = frame.Function.Name
+        }
+        callFrames = append(callFrames, runtimeAgent.CallFrame{
+            FunctionName: functionName,
+            ScriptId: runtimeAgent.ScriptId(frame.File),
+            Url: frame.File,
+            LineNumber: int64(frame.Line - 1),
+        })
+    }
+    description := fmt.Sprintf("goroutine %d", goroutineID)
+    return &runtimeAgent.StackTrace{
+        Description: &description,
+        CallFrames: callFrames,
+    }
+}
+
+// Called by the debugger for every tracepoint the program went past.
+func (p *proxy) TracepointHit(thread dbgClient.Thread) {
+    if thread.Breakpoint == nil || thread.Breakpoint.Name != outputTracepointName || thread.BreakpointInfo == nil {
+        return
+    }
+    arguments := dbgClient.Variables(thread.BreakpointInfo.Arguments)
+    frames := dbgClient.Stackframes(thread.BreakpointInfo.Stacktrace)
+    var file, data *dbgClient.Variable
+    for i := range arguments {
+        switch arguments[i].Name {
+        case "f":
+            file = &arguments[i]
+        case "b":
+            data = &arguments[i]
+        }
+    }
+    if file == nil || data == nil {
+        return
+    }
+    fd, ok := outputFd(*file)
+    if !ok {
+        return
+    }
+    written, truncated := loadedBytes(*data)
+    p.callsiteWrites.Add(fd, &pendingWrite{
+        Remaining: string(written),
+        Truncated: truncated,
+        StackTrace: callsiteStackTrace(thread.GoroutineID, frames),
+    })
+}
+
+// Stack of whatever printed line to fd, if we know it.
+func (p *proxy) callsite(fd int, line string) *runtimeAgent.StackTrace {
+    if !p.Callsites() {
+        return nil
+    }
+    return p.callsiteWrites.Take(fd, line)
+}
@@ -0,0 +1,67 @@
+package runtime
+
+import (
+    "testing"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+func TestMatchWrites(t *testing.T) {
+    tests := []struct {
+        name string
+        writes []*pendingWrite
+        line string
+        used int
+        rest string
+        ok bool
+    }{
+        {"one write", []*pendingWrite{{Remaining: "hello\n"}}, "hello", 1, "", true},
+        {"crlf", []*pendingWrite{{Remaining: "hello\r\n"}}, "hello", 1, "", true},
+        {"without newline", []*pendingWrite{{Remaining: "hello"}}, "hello", 1, "", true},
+        {"line over two writes", []*pendingWrite{{Remaining: "a"}, {Remaining: "b\n"}}, "ab", 2, "", true},
+        {"write of two lines", []*pendingWrite{{Remaining: "one\ntwo\n"}}, "one", 0, "two\n", true},
+        {"truncated write", []*pendingWrite{{Remaining: "abc", Truncated: true}}, "abcdef", 1, "", true},
+        {"line is shorter", []*pendingWrite{{Remaining: "hello world\n"}}, "hello", 0, "", false},
+        {"line is longer", []*pendingWrite{{Remaining: "ab"}}, "abc", 0, "", false},
+        {"other text", []*pendingWrite{{Remaining: "other\n"}}, "line", 0, "", false},
+        {"no writes", nil, "line", 0, "", false},
+    }
+    for _, test := range tests {
+        used, rest, ok := matchWrites(test.writes, test.line)
+        if used != test.used || rest != test.rest || ok != test.ok {
+            t.Errorf("%s: matchWrites(%q) = %d, %q, %v, want %d, %q, %v", test.name, test.line, used, rest, ok,
+                     test.used, test.rest, test.ok)
+        }
+    }
+}
+
+func TestCallsiteQueueTake(t *testing.T) {
+    stack := func(name string) *runtimeAgent.StackTrace {
+        return &runtimeAgent.StackTrace{CallFrames: []runtimeAgent.CallFrame{{FunctionName: name}}}
+    }
+    queue := newCallsiteQueue()
+    queue.Add(1, &pendingWrite{Remaining: "lost\n", StackTrace: stack("main.lost")})
+    queue.Add(1, &pendingWrite{Remaining: "first\nsecond\n", StackTrace: stack("main.both")})
+    queue.Add(2, &pendingWrite{Remaining: "error\n", StackTrace: stack("main.stderr")})
+
+    tests := []struct {
+        fd int
+        line string
+        function string // Empty if no stack is expected.
+    }{
+        {1, "first", "main.both"},
+        {1, "second", "main.both"},
+        {1, "lost", ""},
+        {2, "error", "main.stderr"},
+        {2, "error", ""},
+    }
+    for _, test := range tests {
+        stackTrace := queue.Take(test.fd, test.line)
+        function := ""
+        if stackTrace != nil {
+            function = stackTrace.CallFrames[0].FunctionName
+        }
+        if function != test.function {
+            t.Errorf("Take(%d, %q) is from %q, want %q", test.fd, test.line, function, test.function)
+        }
+    }
+}
@@ -0,0 +1,441 @@
+package runtime
+
+import (
+    "fmt"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "github.com/allada/gdd/config"
+    "github.com/allada/gdd/dbgClient"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// Console lines starting with ':' are commands for the debugger, like ":bt 100", instead of go expressions.
+var consoleCommandPattern = regexp.MustCompile(`^\s*:(\w+)\s*(.*?)\s*$`)
+
+// Frames :bt shows unless told otherwise.
+const defaultBacktraceDepth = 50
+
+// Rows a console table gets. Devtools draws tables from the preview, which has to carry every row.
+const maxTableRows = 1000
+
+type consoleCommand struct {
+    Usage string
+    Help string
+    Paused bool // Needs the program to be paused, delve cannot look at a running one.
+    RawArgs bool // Gets everything after the name as one argument, for commands that take an expression.
+    Run func(p *proxy, scope dbgClient.EvalScope, args []string) (runtimeAgent.RemoteObject, error)
+}
+
+var consoleCommands map[string]consoleCommand
+
+func init() {
+    consoleCommands = map[string]consoleCommand{
+        "help": consoleCommand{":help", "Lists these commands.", false, false, (*proxy).helpCommand},
+        "goroutines": consoleCommand{":goroutines [regexp]", "Table of the goroutines, or of those in functions matching regexp.", true, false, (*proxy).goroutinesCommand},
+        "bt": consoleCommand{":bt [depth]", "Stack of the selected goroutine as a table.", true, false, (*proxy).backtraceCommand},
+        "regs": consoleCommand{":regs", "Registers of the thread the selected goroutine runs on.", true, false, (*proxy).registersCommand},
+        "disassemble": consoleCommand{":disassemble [location]", "Instructions of the current function, or of the function at location (like main.main or file.go:12).", true, false, (*proxy).disassembleCommand},
+        "funcs": consoleCommand{":funcs [regexp]", "Functions of the program.", true, false, (*proxy).functionsCommand},
+        "types": consoleCommand{":types [regexp]", "Types of the program.", true, false, (*proxy).typesCommand},
+        "sources": consoleCommand{":sources [regexp]", "Source files of the program.", true, false, (*proxy).sourcesCommand},
+        "each": consoleCommand{":each [-in regexp] [-frame n] [-depth n] [-user] expression", "Evaluates expression in the first frame outside of GOROOT of every goroutine, or in the first frame of those in functions matching regexp.", true, true, (*proxy).eachCommand},
+        "config": consoleCommand{":config [name value]", "Shows the settings, or changes one, like :config max-string-len 4096.", false, false, (*proxy).configCommand},
+    }
+}
+
+func undefinedObject() runtimeAgent.RemoteObject {
+    return runtimeAgent.RemoteObject{Type: runtimeAgent.RemoteObjectTypeUndefined}
+}
+
+// Runs a :command. ok is false if expression is not one. scope is only asked for by commands that need it.
+func (p *proxy) consoleCommand(expression string, throwOnSideEffect bool, scope func() (dbgClient.EvalScope, error)) (runtimeAgent.RemoteObject, bool, error) {
+    match := consoleCommandPattern.FindStringSubmatch(expression)
+    if match == nil {
+        return runtimeAgent.RemoteObject{}, false, nil
+    }
+    // Eager previews evaluate what is typed so far on every keystroke. Commands only run once it is entered.
+    if throwOnSideEffect {
+        return undefinedObject(), true, fmt.Errorf("Commands are not run in previews.")
+    }
+    command, ok := consoleCommands[match[1]]
+    if !ok {
+        return undefinedObject(), true, fmt.Errorf("Unknown command ':%s', :help lists them.", match[1])
+    }
+    var evalScope dbgClient.EvalScope
+    if command.Paused {
+        if p.Running() {
+            return undefinedObject(), true, fmt.Errorf("Pause the program to use :%s.", match[1])
+        }
+        var err error
+        if evalScope, err = scope(); err != nil {
+            return undefinedObject(), true, err
+        }
+    }
+    args := strings.Fields(match[2])
+    if command.RawArgs {
+        args = []string{match[2]}
+    }
+    result, err := command.Run(p, evalScope, args)
+    return result, true, err
+}
+
+// Sends rows as console.table, each row being a logField whose Value is its cells ([]logField).
+func (p *proxy) sendConsoleTable(rows []logField) {
+    if len(rows) > maxTableRows {
+        rows = rows[:maxTableRows]
+    }
+    subtype := runtimeAgent.RemoteObjectSubtypeArray
+    table := p.jsonObject(rows, fmt.Sprintf("Array(%d)", len(rows)), &subtype)
+    table.Preview.Overflow = false
+    table.Preview.Properties = []runtimeAgent.PropertyPreview{}
+    for _, row := range rows {
+        cells := row.Value.([]logField)
+        description := "Object"
+        rowPreview := &runtimeAgent.ObjectPreview{
+            Type: runtimeAgent.ObjectPreviewTypeObject,
+            Description: &description,
+            Properties: []runtimeAgent.PropertyPreview{},
+        }
+        for _, cell := range cells {
+            rowPreview.Properties = append(rowPreview.Properties, jsonPropertyPreview(cell))
+        }
+        table.Preview.Properties = append(table.Preview.Properties, runtimeAgent.PropertyPreview{
+            Name: row.Key,
+            Type: runtimeAgent.PropertyPreviewTypeObject,
+            Value: &description,
+            ValuePreview: rowPreview,
+        })
+    }
+    p.agent.FireConsoleAPICalled(runtimeAgent.ConsoleAPICalledEvent{
+        Type: runtimeAgent.ConsoleAPICalledTypeTable,
+        Args: []runtimeAgent.RemoteObject{table},
+        Timestamp: now(),
+        ExecutionContextId: executionContextId,
+    })
+}
+
+// Array of names the console can expand, for the list commands.
+func (p *proxy) stringsObject(values []string) runtimeAgent.RemoteObject {
+    elements := []interface{}{}
+    for _, value := range values {
+        elements = append(elements, value)
+    }
+    return p.jsonRemoteObject(elements)
+}
+
+func locationString(location dbgClient.Location) string {
+    if location.File == "" {
+        return fmt.Sprintf("%#x", location.PC)
+    }
+    return fmt.Sprintf("%s:%d", location.File, location.Line)
+}
+
+func locationFunction(location dbgClient.Location) string {
+    if location.Function == nil {
+        return ""
+    }
+    return location.Function.Name
+}
+
+// An optional regexp argument. No argument matches everything.
+func filterArg(args []string) (*regexp.Regexp, error) {
+    if len(args) == 0 {
+        return regexp.Compile("")
+    }
+    return regexp.Compile(strings.Join(args, " "))
+}
+
+func (p *proxy) helpCommand(_ dbgClient.EvalScope, _ []string) (runtimeAgent.RemoteObject, error) {
+    names := []string{}
+    for name := range consoleCommands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    rows := []logField{}
+    for _, name := range names {
+        command := consoleCommands[name]
+        rows = append(rows, logField{command.Usage, []logField{{"Help", command.Help}}})
+    }
+    p.sendConsoleTable(rows)
+    return undefinedObject(), nil
+}
+
+func (p *proxy) goroutinesCommand(_ dbgClient.EvalScope, args []string) (runtimeAgent.RemoteObject, error) {
+    filter, err := filterArg(args)
+    if err != nil {
+        return undefinedObject(), err
+    }
+    goroutines, err := p.client.ListGoroutines()
+    if err != nil {
+        return undefinedObject(), err
+    }
+    rows := []logField{}
+    for _, goroutine := range goroutines {
+        current := dbgClient.Location(goroutine.UserCurrentLoc)
+        if filter.String() != "" && !filter.MatchString(locationFunction(dbgClient.Location(goroutine.CurrentLoc))) &&
+           !filter.MatchString(locationFunction(current)) {
+            continue
+        }
+        cells := []logField{
+            {"Function", locationFunction(current)},
+            {"Location", locationString(current)},
+            {"Started at", locationString(dbgClient.Location(goroutine.GoStatementLoc))},
+        }
+        if goroutine.ThreadID != 0 {
+            cells = append(cells, logField{"Thread", float64(goroutine.ThreadID)})
+        }
+        rows = append(rows, logField{strconv.Itoa(goroutine.ID), cells})
+    }
+    p.sendConsoleTable(rows)
+    return undefinedObject(), nil
+}
+
+func (p *proxy) backtraceCommand(scope dbgClient.EvalScope, args []string) (runtimeAgent.RemoteObject, error) {
+    depth := defaultBacktraceDepth
+    if len(args) > 0 {
+        var err error
+        if depth, err = strconv.Atoi(args[0]); err != nil || depth <= 0 {
+            return undefinedObject(), fmt.Errorf("Depth must be a positive number.")
+        }
+    }
+    frames, err := p.client.Stacktrace(scope.GoroutineID, depth, nil)
+    if err != nil {
+        return undefinedObject(), err
+    }
+    rows := []logField{}
+    for i, frame := range frames {
+        rows = append(rows, logField{strconv.Itoa(i), []logField{
+            {"Function", locationFunction(dbgClient.Location(frame.Location))},
+            {"Location", locationString(dbgClient.Location(frame.Location))},
+            {"PC", fmt.Sprintf("%#x", frame.PC)},
+        }})
+    }
+    p.sendConsoleTable(rows)
+    return undefinedObject(), nil
+}
+
+// Thread the goroutine of scope is running on, or the current thread if it is parked.
+func (p *proxy) scopeThreadID(scope dbgClient.EvalScope) (int, error) {
+    goroutines, err := p.client.ListGoroutines()
+    if err != nil {
+        return 0, err
+    }
+    for _, goroutine := range goroutines {
+        if goroutine.ID == scope.GoroutineID && goroutine.ThreadID != 0 {
+            return goroutine.ThreadID, nil
+        }
+    }
+    state, err := p.client.GetState()
+    if err != nil {
+        return 0, err
+    }
+    if state.CurrentThread == nil {
+        return 0, fmt.Errorf("There is no thread to read registers from.")
+    }
+    return state.CurrentThread.ID, nil
+}
+
+func (p *proxy) registersCommand(scope dbgClient.EvalScope, _ []string) (runtimeAgent.RemoteObject, error) {
+    threadID, err := p.scopeThreadID(scope)
+    if err != nil {
+        return undefinedObject(), err
+    }
+    registers, err := p.client.ListRegisters(threadID, false)
+    if err != nil {
+        return undefinedObject(), err
+    }
+    fields := []logField{}
+    for _, register := range registers {
+        fields = append(fields, logField{register.Name, register.Value})
+    }
+    return p.jsonObject(fields, fmt.Sprintf("Registers of thread %d", threadID), nil), nil
+}
+
+func (p *proxy) disassembleCommand(scope dbgClient.EvalScope, args []string) (runtimeAgent.RemoteObject, error) {
+    var pc uint64
+    if len(args) > 0 {
+        locations, err := p.client.FindLocation(scope, strings.Join(args, " "))
+        if err != nil {
+            return undefinedObject(), err
+        }
+        if len(locations) == 0 {
+            return undefinedObject(), fmt.Errorf("Could not find %s", strings.Join(args, " "))
+        }
+        pc = locations[0].PC
+    } else {
+        frames, err := p.client.Stacktrace(scope.GoroutineID, scope.Frame + 1, nil)
+        if err != nil {
+            return undefinedObject(), err
+        }
+        if len(frames) <= scope.Frame {
+            return undefinedObject(), fmt.Errorf("There is no frame %d", scope.Frame)
+        }
+        pc = frames[scope.Frame].PC
+    }
+    instructions, err := p.client.DisassemblePC(scope, pc)
+    if err != nil {
+        return undefinedObject(), err
+    }
+    rows := []logField{}
+    for _, instruction := range instructions {
+        marker := ""
+        switch {
+        case instruction.AtPC:
+            marker = "=>"
+        case instruction.Breakpoint:
+            marker = "*"
+        }
+        cells := []logField{
+            {"At", marker},
+            {"Location", filepath.Base(instruction.Loc.File) + ":" + strconv.Itoa(instruction.Loc.Line)},
+            {"Instruction", instruction.Text},
+        }
+        if instruction.DestLoc != nil && instruction.DestLoc.Function != nil {
+            cells = append(cells, logField{"Target", instruction.DestLoc.Function.Name})
+        }
+        rows = append(rows, logField{fmt.Sprintf("%#x", instruction.Loc.PC), cells})
+    }
+    p.sendConsoleTable(rows)
+    return undefinedObject(), nil
+}
+
+func (p *proxy) functionsCommand(_ dbgClient.EvalScope, args []string) (runtimeAgent.RemoteObject, error) {
+    filter, err := filterArg(args)
+    if err != nil {
+        return undefinedObject(), err
+    }
+    functions, err := p.client.ListFunctions(filter.String())
+    if err != nil {
+        return undefinedObject(), err
+    }
+    return p.stringsObject(functions), nil
+}
+
+func (p *proxy) typesCommand(_ dbgClient.EvalScope, args []string) (runtimeAgent.RemoteObject, error) {
+    filter, err := filterArg(args)
+    if err != nil {
+        return undefinedObject(), err
+    }
+    types, err := p.client.ListTypes(filter.String())
+    if err != nil {
+        return undefinedObject(), err
+    }
+    return p.stringsObject(types), nil
+}
+
+func (p *proxy) sourcesCommand(_ dbgClient.EvalScope, args []string) (runtimeAgent.RemoteObject, error) {
+    filter, err := filterArg(args)
+    if err != nil {
+        return undefinedObject(), err
+    }
+    sources, err := p.client.ListSources()
+    if err != nil {
+        return undefinedObject(), err
+    }
+    matching := []string{}
+    for _, source := range sources {
+        if filter.MatchString(source) {
+            matching = append(matching, source)
+        }
+    }
+    return p.stringsObject(matching), nil
+}
+
+// Settings that live in the debugger proxy, which lists the goroutines and threads.
+type DebuggerSettings interface {
+    GoroutineFilter() config.GoroutineFilter
+    SetGoroutineFilter(filter config.GoroutineFilter)
+    ShowThreads() bool
+    SetShowThreads(show bool)
+}
+
+// Lets :config show and change the settings of the debugger too.
+func (p *proxy) SetDebuggerSettings(settings DebuggerSettings) {
+    p.evalScopeHandlerMux.Lock()
+    defer p.evalScopeHandlerMux.Unlock()
+    p.debuggerSettings = settings
+}
+
+func (p *proxy) getDebuggerSettings() DebuggerSettings {
+    p.evalScopeHandlerMux.RLock()
+    defer p.evalScopeHandlerMux.RUnlock()
+    return p.debuggerSettings
+}
+
+// Settings :config can change, named like their command line flags.
+func (p *proxy) configFields() []logField {
+    limits := p.LoadLimits()
+    fields := []logField{
+        {"max-string-len", float64(limits.MaxStringLen)},
+        {"max-array-values", float64(limits.MaxArrayValues)},
+        {"copy-depth", float64(limits.CopyDepth)},
+        {"callsites", p.Callsites()},
+        {"verbose", p.Verbose()},
+    }
+    if settings := p.getDebuggerSettings(); settings != nil {
+        fields = append(fields, logField{"goroutines", settings.GoroutineFilter().String()})
+        fields = append(fields, logField{"threads", settings.ShowThreads()})
+    }
+    return fields
+}
+
+func (p *proxy) configCommand(_ dbgClient.EvalScope, args []string) (runtimeAgent.RemoteObject, error) {
+    switch len(args) {
+    case 0:
+        return p.jsonObject(p.configFields(), "Config", nil), nil
+    case 2:
+    default:
+        return undefinedObject(), fmt.Errorf("Usage: :config [name value]")
+    }
+    name, value := args[0], args[1]
+    limits := p.LoadLimits()
+    switch name {
+    case "max-string-len", "max-array-values", "copy-depth":
+        number, err := strconv.Atoi(value)
+        if err != nil || number <= 0 {
+            return undefinedObject(), fmt.Errorf("Value for '%s' must be a positive number.", name)
+        }
+        switch name {
+        case "max-string-len":
+            limits.MaxStringLen = number
+        case "max-array-values":
+            limits.MaxArrayValues = number
+        case "copy-depth":
+            limits.CopyDepth = number
+        }
+        p.SetLoadLimits(limits)
+    case "verbose":
+        verbose, err := strconv.ParseBool(value)
+        if err != nil {
+            return undefinedObject(), fmt.Errorf("Value for '%s' must be true or false.", name)
+        }
+        p.SetVerbose(verbose)
+    case "goroutines", "threads":
+        settings := p.getDebuggerSettings()
+        if settings == nil {
+            return undefinedObject(), fmt.Errorf("Unknown setting '%s'", name)
+        }
+        if name == "threads" {
+            show, err := strconv.ParseBool(value)
+            if err != nil {
+                return undefinedObject(), fmt.Errorf("Value for '%s' must be true or false.", name)
+            }
+            settings.SetShowThreads(show)
+            break
+        }
+        filter, ok := config.ParseGoroutineFilter(value)
+        if !ok {
+            return undefinedObject(), fmt.Errorf("Value for '%s' must be a comma separated list of: all, user, hide-system, group.", name)
+        }
+        settings.SetGoroutineFilter(filter)
+    case "callsites":
+        // The tracepoint behind it is set up when the program starts.
+        return undefinedObject(), fmt.Errorf("'callsites' can only be set on the command line.")
+    default:
+        return undefinedObject(), fmt.Errorf("Unknown setting '%s'", name)
+    }
+    return p.jsonObject(p.configFields(), "Config", nil), nil
+}
@@ -0,0 +1,344 @@
+package runtime
+
+import (
+    "encoding/json"
+    "fmt"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/allada/gdd/dbgClient"
+    "github.com/allada/gdd/protocol/shared"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// Strings are loaded whole for copying, up to this many bytes.
+const copyMaxStringLen = 1 << 16
+
+// Devtools' "Copy object" calls a function by this name on the object and puts what it returns on the clipboard.
+var copyFunctionPattern = regexp.MustCompile(`\btoStringForClipboard\b`)
+
+// copy(x) copies x as a go literal and copyJSON(x) as json, like copy() of the devtools command line api.
+var copyCommandPattern = regexp.MustCompile(`(?s)^\s*copy(JSON)?\((.+)\)\s*;?\s*$`)
+
+// Splits copy(x) and copyJSON(x) into the expression to copy. Go's own copy(dst, src) has two arguments and is left
+// to delve, as is anything where the parentheses around x do not belong together.
+func parseCopyCommand(expression string) (bool, string, bool) {
+    match := copyCommandPattern.FindStringSubmatch(expression)
+    if match == nil {
+        return false, "", false
+    }
+    depth := 0
+    topLevel := true
+    replaceOutsideStrings(match[2], func(code string) string {
+        for _, c := range code {
+            switch c {
+            case '(', '[', '{':
+                depth++
+            case ')', ']', '}':
+                depth--
+            case ',':
+                if depth == 0 {
+                    topLevel = false
+                }
+            }
+            if depth < 0 {
+                topLevel = false
+            }
+        }
+        return code
+    })
+    if !topLevel || depth != 0 {
+        return false, "", false
+    }
+    return match[1] != "", match[2], true
+}
+
+// Package paths make types too long to paste, example.com/pkg.T is written pkg.T and main.T just T. The name a
+// package goes by is not always the end of its path, gopkg.in/yaml.v2 is yaml, so packageName gives it.
+func shortTypeName(typeName string, packageName func(importPath string) string) string {
+    return qualifiedNamePattern.ReplaceAllStringFunc(typeName, func(name string) string {
+        dot := strings.LastIndex(name, ".")
+        switch {
+        case dot < 0 || dot < strings.LastIndex(name, "/"):
+            return name
+        case name[:dot] == "main":
+            return name[dot + 1:]
+        }
+        return packageName(name[:dot]) + name[dot:]
+    })
+}
+
+// NaN and the infinities have no literal, math has functions for them. Delve prints them like strconv does.
+func nonFiniteFloat(value string) (string, bool) {
+    switch value {
+    case "NaN":
+        return "math.NaN()", true
+    case "+Inf":
+        return "math.Inf(1)", true
+    case "-Inf":
+        return "math.Inf(-1)", true
+    }
+    return "", false
+}
+
+// Types untyped constants already default to, so they need no conversion inside an interface.
+var defaultConstantTypes = map[string]struct{}{
+    "int": struct{}{},
+    "float64": struct{}{},
+    "string": struct{}{},
+    "bool": struct{}{},
+}
+
+// Copies load the whole value down to CopyDepth in one go, whatever is deeper is left out.
+func (p *proxy) copyLoadConfig() dbgClient.LoadConfig {
+    cfg := p.LoadConfig()
+    cfg.MaxVariableRecurse = p.LoadLimits().CopyDepth
+    cfg.MaxStringLen = copyMaxStringLen
+    return cfg
+}
+
+// Writes a loaded value as go source. Pointers seen on the way down are remembered, so a value that points back at
+// itself is cut off instead of being written out again until the depth runs out.
+type literalWriter struct {
+    p *proxy
+    path map[uint64]struct{}
+}
+
+func (p *proxy) goLiteral(variable dbgClient.Variable) string {
+    w := &literalWriter{
+        p: p,
+        path: map[uint64]struct{}{},
+    }
+    return w.write(variable, false)
+}
+
+func isZeroLiteral(literal string) bool {
+    switch literal {
+    case "0", "false", `""`, "nil", "0+0i", "(0+0i)":
+        return true
+    }
+    return false
+}
+
+func (w *literalWriter) elements(variable dbgClient.Variable) string {
+    elements := []string{}
+    for _, element := range children(variable) {
+        elements = append(elements, w.write(element, false))
+    }
+    if missing := variable.Len - int64(len(variable.Children)); variable.Kind != reflect.Map && missing > 0 {
+        elements = append(elements, fmt.Sprintf("/* %d more */", missing))
+    }
+    return strings.Join(elements, ", ")
+}
+
+// inInterface is set for values whose type the literal has to carry, since nothing around it gives one.
+func (w *literalWriter) write(variable dbgClient.Variable, inInterface bool) string {
+    typeName := shortTypeName(variable.Type, w.p.packageName)
+    if variable.Unreadable != "" {
+        return "nil /* unreadable: " + variable.Unreadable + " */"
+    }
+    switch variable.Kind {
+    case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint,
+         reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32,
+         reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.String:
+        literal := variable.Value
+        switch variable.Kind {
+        case reflect.String:
+            literal = strconv.Quote(variable.Value)
+            if isTruncatedString(variable) {
+                literal += fmt.Sprintf(" /* %d more bytes */", variable.Len - int64(len(variable.Value)))
+            }
+        case reflect.Complex64, reflect.Complex128:
+            literal = complexValue(variable)
+        case reflect.Float32, reflect.Float64:
+            if call, ok := nonFiniteFloat(variable.Value); ok {
+                // The functions return float64, anything else has to be converted even where the type is known.
+                if typeName == "float64" {
+                    return call
+                }
+                return typeName + "(" + call + ")"
+            }
+        }
+        if _, ok := defaultConstantTypes[typeName]; inInterface && !ok {
+            return typeName + "(" + literal + ")"
+        }
+        return literal
+    case reflect.Struct:
+        if variable.Type == "time.Time" {
+            if description, ok := formatTime(w.p, variable); ok {
+                if t, err := time.Parse(time.RFC3339Nano, strings.SplitN(description, " ", 2)[0]); err == nil {
+                    t = t.UTC()
+                    return fmt.Sprintf("time.Date(%d, %d, %d, %d, %d, %d, %d, time.UTC)", t.Year(), t.Month(), t.Day(),
+                                       t.Hour(), t.Minute(), t.Second(), t.Nanosecond())
+                }
+            }
+        }
+        if int64(len(variable.Children)) < variable.Len {
+            return typeName + "{} /* not loaded, too deep */"
+        }
+        fields := []string{}
+        for _, field := range children(variable) {
+            literal := w.write(field, false)
+            if !isZeroLiteral(literal) {
+                fields = append(fields, field.Name + ": " + literal)
+            }
+        }
+        return typeName + "{" + strings.Join(fields, ", ") + "}"
+    case reflect.Array:
+        return typeName + "{" + w.elements(variable) + "}"
+    case reflect.Slice:
+        if isNil(variable) {
+            return "nil"
+        }
+        return typeName + "{" + w.elements(variable) + "}"
+    case reflect.Map:
+        if isNil(variable) {
+            return "nil"
+        }
+        entries := []string{}
+        pairs := children(variable)
+        for i := 0; i + 1 < len(pairs); i += 2 {
+            entries = append(entries, w.write(pairs[i], false) + ": " + w.write(pairs[i + 1], false))
+        }
+        if missing := variable.Len - int64(len(pairs) / 2); missing > 0 {
+            entries = append(entries, fmt.Sprintf("/* %d more */", missing))
+        }
+        return typeName + "{" + strings.Join(entries, ", ") + "}"
+    case reflect.Ptr:
+        if isNil(variable) {
+            return "nil"
+        }
+        address := pointerValue(variable)
+        target := children(variable)[0]
+        if _, ok := w.path[address]; ok {
+            return fmt.Sprintf("nil /* cycle back to %#x */", address)
+        }
+        if target.OnlyAddr || target.Kind == reflect.Invalid {
+            return fmt.Sprintf("nil /* %#x, too deep */", address)
+        }
+        w.path[address] = struct{}{}
+        defer delete(w.path, address)
+        literal := w.write(target, false)
+        switch target.Kind {
+        case reflect.Struct, reflect.Array, reflect.Slice, reflect.Map:
+            if strings.HasPrefix(literal, shortTypeName(target.Type, w.p.packageName) + "{") {
+                return "&" + literal
+            }
+        }
+        // Go cannot take the address of other literals, a function can.
+        return fmt.Sprintf("func() %s { v := %s(%s); return &v }()", typeName, shortTypeName(target.Type, w.p.packageName), literal)
+    case reflect.Interface:
+        if isNil(variable) {
+            return "nil"
+        }
+        return w.write(children(variable)[0], true)
+    }
+    // Channels, functions and unsafe pointers have no literal.
+    return "nil /* " + w.p.describeVariable(variable) + " */"
+}
+
+// Json for a loaded value. Formatted types are written as their description, pointer cycles as null.
+func (p *proxy) copyJSONValue(variable dbgClient.Variable, path map[uint64]struct{}) interface{} {
+    switch variable.Kind {
+    case reflect.Struct, reflect.Array, reflect.Slice, reflect.Interface:
+        if description, ok := p.format(variable); ok {
+            return description
+        }
+    }
+    switch variable.Kind {
+    case reflect.Struct:
+        fields := map[string]interface{}{}
+        for _, field := range children(variable) {
+            fields[field.Name] = p.copyJSONValue(field, path)
+        }
+        return fields
+    case reflect.Array, reflect.Slice:
+        if isNil(variable) {
+            return nil
+        }
+        elements := []interface{}{}
+        for _, element := range children(variable) {
+            elements = append(elements, p.copyJSONValue(element, path))
+        }
+        return elements
+    case reflect.Map:
+        if isNil(variable) {
+            return nil
+        }
+        entries := map[string]interface{}{}
+        pairs := children(variable)
+        for i := 0; i + 1 < len(pairs); i += 2 {
+            key := pairs[i].Value
+            if pairs[i].Kind != reflect.String {
+                key = p.describeVariable(pairs[i])
+            }
+            entries[key] = p.copyJSONValue(pairs[i + 1], path)
+        }
+        return entries
+    case reflect.Ptr:
+        address := pointerValue(variable)
+        if _, ok := path[address]; ok || isNil(variable) {
+            return nil
+        }
+        path[address] = struct{}{}
+        defer delete(path, address)
+        return p.copyJSONValue(children(variable)[0], path)
+    case reflect.Interface:
+        if isNil(variable) {
+            return nil
+        }
+        return p.copyJSONValue(children(variable)[0], path)
+    }
+    return p.jsonValue(variable)
+}
+
+func (p *proxy) copyJSON(variable dbgClient.Variable) string {
+    data, err := json.MarshalIndent(p.copyJSONValue(variable, map[uint64]struct{}{}), "", "  ")
+    if err != nil {
+        return err.Error()
+    }
+    return string(data)
+}
+
+// For "Copy object" in devtools, which wants the text for the clipboard back.
+func (p *proxy) copyObjectAndRespond(command runtimeAgent.CallFunctionOnCommand, ref objectRef) {
+    variable, err := p.client.EvalVariable(ref.Scope, ref.Expression, p.copyLoadConfig())
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    command.Respond(&runtimeAgent.CallFunctionOnReturn{
+        Result: runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeString,
+            Value: p.goLiteral(*variable),
+        },
+    })
+}
+
+// Runs copy(x) and copyJSON(x) from the console. The frontend copies what comes with the inspectRequested event.
+func (p *proxy) copyCommand(scope dbgClient.EvalScope, asJSON bool, expression string) error {
+    expression, err := p.expandTemps(expression)
+    if err != nil {
+        return err
+    }
+    variable, err := p.client.EvalVariable(scope, expression, p.copyLoadConfig())
+    if err != nil {
+        return err
+    }
+    text := p.goLiteral(*variable)
+    if asJSON {
+        text = p.copyJSON(*variable)
+    }
+    p.agent.FireInspectRequested(runtimeAgent.InspectRequestedEvent{
+        Object: runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeString,
+            Value: text,
+        },
+        Hints: map[string]string{
+            "copyToClipboard": "true",
+        },
+    })
+    return nil
+}
@@ -0,0 +1,105 @@
+package runtime
+
+import (
+    "testing"
+)
+
+func TestParseCopyCommand(t *testing.T) {
+    tests := []struct {
+        expression string
+        asJSON bool
+        argument string
+        ok bool
+    }{
+        {"copy(x)", false, "x", true},
+        {"  copyJSON(a.b[3]);  ", true, "a.b[3]", true},
+        {"copy(f(a, b))", false, "f(a, b)", true},
+        {`copy(m["a,b"])`, false, `m["a,b"]`, true},
+        {`copy(s == ")")`, false, `s == ")"`, true},
+        {"copy(a, b)", false, "", false},
+        {"copy(a)(b)", false, "", false},
+        {"copy()", false, "", false},
+        {"copyx(a)", false, "", false},
+        {"x + copy(a)", false, "", false},
+    }
+    for _, test := range tests {
+        asJSON, argument, ok := parseCopyCommand(test.expression)
+        if asJSON != test.asJSON || argument != test.argument || ok != test.ok {
+            t.Errorf("parseCopyCommand(%q) = %v, %q, %v, want %v, %q, %v", test.expression, asJSON, argument, ok,
+                     test.asJSON, test.argument, test.ok)
+        }
+    }
+}
+
+func TestShortTypeName(t *testing.T) {
+    packageNames := map[string]string{
+        "gopkg.in/yaml.v2": "yaml",
+        "github.com/go-kit/kit/log": "log",
+        "example.com/pkg": "pkg",
+    }
+    packageName := func(importPath string) string {
+        if name, ok := packageNames[importPath]; ok {
+            return name
+        }
+        return importPath
+    }
+    tests := []struct {
+        typeName string
+        want string
+    }{
+        {"int", "int"},
+        {"main.T", "T"},
+        {"[]*main.T", "[]*T"},
+        {"time.Time", "time.Time"},
+        {"example.com/pkg.T", "pkg.T"},
+        {"gopkg.in/yaml.v2.Node", "yaml.Node"},
+        {"map[string]*gopkg.in/yaml.v2.Node", "map[string]*yaml.Node"},
+        {"map[main.K][]github.com/go-kit/kit/log.Logger", "map[K][]log.Logger"},
+        {"struct { A main.T; B example.com/pkg.U }", "struct { A T; B pkg.U }"},
+    }
+    for _, test := range tests {
+        if got := shortTypeName(test.typeName, packageName); got != test.want {
+            t.Errorf("shortTypeName(%q) = %q, want %q", test.typeName, got, test.want)
+        }
+    }
+}
+
+func TestAssumedPackageName(t *testing.T) {
+    tests := []struct {
+        importPath string
+        want string
+    }{
+        {"fmt", "fmt"},
+        {"net/http", "http"},
+        {"gopkg.in/yaml.v2", "yaml"},
+        {"github.com/go-redis/redis/v8", "redis"},
+        {"github.com/mattn/go-sqlite3", "sqlite3"},
+        {"github.com/foo/bar-baz", "bar"},
+        {"github.com/foo/v2x", "v2x"},
+    }
+    for _, test := range tests {
+        if got := assumedPackageName(test.importPath); got != test.want {
+            t.Errorf("assumedPackageName(%q) = %q, want %q", test.importPath, got, test.want)
+        }
+    }
+}
+
+func TestNonFiniteFloat(t *testing.T) {
+    tests := []struct {
+        value string
+        want string
+        ok bool
+    }{
+        {"NaN", "math.NaN()", true},
+        {"+Inf", "math.Inf(1)", true},
+        {"-Inf", "math.Inf(-1)", true},
+        {"1.5", "", false},
+        {"1e+300", "", false},
+    }
+    for _, test := range tests {
+        got, ok := nonFiniteFloat(test.value)
+        if got != test.want || ok != test.ok {
+            t.Errorf("nonFiniteFloat(%q) = %q, %v, want %q, %v", test.value, got, ok, test.want, test.ok)
+        }
+    }
+}
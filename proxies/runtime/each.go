@@ -0,0 +1,185 @@
+package runtime
+
+import (
+    "fmt"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "github.com/allada/gdd/dbgClient"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// How many frames of each goroutine are searched for the frame to evaluate in, unless -depth says otherwise.
+const defaultEachDepth = 200
+
+// Options of :each come before the expression, like ":each -in ServeHTTP r.URL.Path".
+type eachOptions struct {
+    In *regexp.Regexp // Evaluate in the first frame of a function matching this, skip goroutines that have none.
+    Frame int // Frame to evaluate in, counted from the frame In found or the first one outside of GOROOT.
+    Depth int // Frames searched for In.
+    User bool // Only goroutines that are in code outside of GOROOT.
+    Expression string
+}
+
+// Splits the options off the front of args, the rest is the expression as typed.
+func parseEachArgs(args string) (eachOptions, error) {
+    options := eachOptions{
+        Depth: defaultEachDepth,
+    }
+    for {
+        args = strings.TrimSpace(args)
+        if !strings.HasPrefix(args, "-") {
+            break
+        }
+        fields := strings.SplitN(args, " ", 2)
+        rest := ""
+        if len(fields) == 2 {
+            rest = fields[1]
+        }
+        value := func() (string, error) {
+            valueFields := strings.SplitN(strings.TrimSpace(rest), " ", 2)
+            if valueFields[0] == "" {
+                return "", fmt.Errorf("%s needs a value", fields[0])
+            }
+            rest = ""
+            if len(valueFields) == 2 {
+                rest = valueFields[1]
+            }
+            return valueFields[0], nil
+        }
+        switch fields[0] {
+        case "-in":
+            pattern, err := value()
+            if err != nil {
+                return options, err
+            }
+            if options.In, err = regexp.Compile(pattern); err != nil {
+                return options, err
+            }
+        case "-frame":
+            frame, err := value()
+            if err != nil {
+                return options, err
+            }
+            if options.Frame, err = strconv.Atoi(frame); err != nil || options.Frame < 0 {
+                return options, fmt.Errorf("-frame must be a number of 0 or more")
+            }
+        case "-depth":
+            depth, err := value()
+            if err != nil {
+                return options, err
+            }
+            if options.Depth, err = strconv.Atoi(depth); err != nil || options.Depth < 1 {
+                return options, fmt.Errorf("-depth must be a number of 1 or more")
+            }
+        case "-user":
+            options.User = true
+        default:
+            // Not an option, a negative number or -x starts the expression.
+            options.Expression = args
+            return options, nil
+        }
+        args = rest
+    }
+    options.Expression = args
+    if options.Expression == "" {
+        return options, fmt.Errorf("Usage: :each [-in regexp] [-frame n] [-depth n] [-user] expression")
+    }
+    return options, nil
+}
+
+// Frame of goroutine to evaluate in and its function. The frame is -1 if the goroutine is left out. Without -in that
+// is the first frame outside of GOROOT, a parked goroutine is in runtime.gopark otherwise.
+func (p *proxy) eachFrame(goroutine *dbgClient.Goroutine, options eachOptions) (int, string, error) {
+    if options.In == nil && options.Frame == 0 && !dbgClient.IsGorootFile(goroutine.CurrentLoc.File) {
+        // Already in user code, no need to ask for the stack.
+        return 0, locationFunction(dbgClient.Location(goroutine.CurrentLoc)), nil
+    }
+    frames, err := p.client.Stacktrace(goroutine.ID, options.Depth + options.Frame, nil)
+    if err != nil {
+        return 0, "", err
+    }
+    start := -1
+    for i, frame := range frames {
+        if i >= options.Depth {
+            break
+        }
+        location := dbgClient.Location(frame.Location)
+        if options.In != nil && options.In.MatchString(locationFunction(location)) ||
+           options.In == nil && !dbgClient.IsGorootFile(location.File) {
+            start = i
+            break
+        }
+    }
+    if start < 0 {
+        if options.In != nil {
+            return -1, "", nil
+        }
+        // Only runtime frames, like the GC workers.
+        start = 0
+    }
+    if start + options.Frame >= len(frames) {
+        return -1, "", nil
+    }
+    return start + options.Frame, locationFunction(dbgClient.Location(frames[start + options.Frame].Location)), nil
+}
+
+// What a table cell shows for a value. Strings go in unquoted, the rest as the console would describe them.
+func (p *proxy) cellValue(variable dbgClient.Variable) string {
+    if variable.Kind == reflect.String && variable.Unreadable == "" && !isTruncatedString(variable) {
+        if _, ok := p.format(variable); !ok {
+            return variable.Value
+        }
+    }
+    return p.describeVariable(variable)
+}
+
+// Evaluates an expression in many goroutines at once, for questions like "what is every request doing".
+func (p *proxy) eachCommand(_ dbgClient.EvalScope, args []string) (runtimeAgent.RemoteObject, error) {
+    options, err := parseEachArgs(args[0])
+    if err != nil {
+        return undefinedObject(), err
+    }
+    expression, err := p.expandTemps(options.Expression)
+    if err != nil {
+        return undefinedObject(), err
+    }
+    goroutines, err := p.client.ListGoroutines()
+    if err != nil {
+        return undefinedObject(), err
+    }
+    rows := []logField{}
+    for _, goroutine := range goroutines {
+        if len(rows) == maxTableRows {
+            break
+        }
+        if options.User && dbgClient.IsGorootFile(goroutine.UserCurrentLoc.File) {
+            continue
+        }
+        frame, function, err := p.eachFrame(goroutine, options)
+        if err != nil {
+            rows = append(rows, logField{strconv.Itoa(goroutine.ID), []logField{{"Error", err.Error()}}})
+            continue
+        }
+        if frame < 0 {
+            continue
+        }
+        cells := []logField{
+            {"Function", function},
+        }
+        scope := dbgClient.EvalScope{GoroutineID: goroutine.ID, Frame: frame}
+        variable, err := p.client.EvalVariable(scope, expression, p.LoadConfig())
+        if err != nil {
+            cells = append(cells, logField{"Error", err.Error()})
+        } else {
+            cells = append(cells, logField{"Value", p.cellValue(*variable)})
+        }
+        rows = append(rows, logField{strconv.Itoa(goroutine.ID), cells})
+    }
+    if len(rows) == 0 {
+        return undefinedObject(), fmt.Errorf("No goroutine matched.")
+    }
+    p.sendConsoleTable(rows)
+    return undefinedObject(), nil
+}
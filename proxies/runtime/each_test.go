@@ -0,0 +1,54 @@
+package runtime
+
+import (
+    "testing"
+)
+
+func TestParseEachArgs(t *testing.T) {
+    tests := []struct {
+        args string
+        in string // Pattern of In, empty if there is none.
+        frame int
+        depth int
+        user bool
+        expression string
+        err bool
+    }{
+        {args: "r.URL.Path", depth: defaultEachDepth, expression: "r.URL.Path"},
+        {args: "  -in ServeHTTP r.URL.Path ", in: "ServeHTTP", depth: defaultEachDepth, expression: "r.URL.Path"},
+        {args: "-frame 2 -depth 50 -user x + y", frame: 2, depth: 50, user: true, expression: "x + y"},
+        {args: "-user  -in   main\\.  len(s)", in: "main\\.", depth: defaultEachDepth, user: true, expression: "len(s)"},
+        {args: "-1 + x", depth: defaultEachDepth, expression: "-1 + x"},
+        {args: "-x", depth: defaultEachDepth, expression: "-x"},
+        {args: "", err: true},
+        {args: "-user", err: true},
+        {args: "-in", err: true},
+        {args: "-in ( x", err: true},
+        {args: "-frame -1 x", err: true},
+        {args: "-frame a x", err: true},
+        {args: "-depth 0 x", err: true},
+    }
+    for _, test := range tests {
+        options, err := parseEachArgs(test.args)
+        if test.err {
+            if err == nil {
+                t.Errorf("parseEachArgs(%q) did not fail", test.args)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("parseEachArgs(%q) failed: %v", test.args, err)
+            continue
+        }
+        in := ""
+        if options.In != nil {
+            in = options.In.String()
+        }
+        if in != test.in || options.Frame != test.frame || options.Depth != test.depth || options.User != test.user ||
+           options.Expression != test.expression {
+            t.Errorf("parseEachArgs(%q) = %q, %d, %d, %v, %q, want %q, %d, %d, %v, %q", test.args, in, options.Frame,
+                     options.Depth, options.User, options.Expression, test.in, test.frame, test.depth, test.user,
+                     test.expression)
+        }
+    }
+}
@@ -0,0 +1,242 @@
+package runtime
+
+import (
+    "fmt"
+    "reflect"
+    "strconv"
+    "github.com/allada/gdd/dbgClient"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// How deep values are loaded when the frontend asks for them by value instead of by object id.
+const returnByValueRecurse = 3
+
+// Lets the debugger tell us where console expressions run, since it knows which goroutine is selected.
+func (p *proxy) SetEvalScopeHandler(handler func(targetID string) (dbgClient.EvalScope, error)) {
+    p.evalScopeHandlerMux.Lock()
+    defer p.evalScopeHandlerMux.Unlock()
+    p.evalScopeHandler = handler
+}
+
+// Lets the debugger know which frame the user looks at, the frontend asks for its locals when one is selected.
+func (p *proxy) SetFrameSelectedHandler(handler func(goroutineID int, frame int)) {
+    p.evalScopeHandlerMux.Lock()
+    defer p.evalScopeHandlerMux.Unlock()
+    p.frameSelectedHandler = handler
+}
+
+func (p *proxy) frameSelected(goroutineID int, frame int) {
+    p.evalScopeHandlerMux.RLock()
+    handler := p.frameSelectedHandler
+    p.evalScopeHandlerMux.RUnlock()
+    if handler != nil {
+        handler(goroutineID, frame)
+    }
+}
+
+// Lets the debugger know a function call ran the program, so it can send the new pause state.
+func (p *proxy) SetCallReturnedHandler(handler func(goroutineID int)) {
+    p.evalScopeHandlerMux.Lock()
+    defer p.evalScopeHandlerMux.Unlock()
+    p.callReturnedHandler = handler
+}
+
+func (p *proxy) callReturned(goroutineID int) {
+    p.evalScopeHandlerMux.RLock()
+    handler := p.callReturnedHandler
+    p.evalScopeHandlerMux.RUnlock()
+    if handler != nil {
+        handler(goroutineID)
+    }
+}
+
+// Lets the debugger announce source files we point the frontend at, since it keeps track of the scripts.
+func (p *proxy) SetScriptHandler(handler func(targetID string, file string)) {
+    p.evalScopeHandlerMux.Lock()
+    defer p.evalScopeHandlerMux.Unlock()
+    p.scriptHandler = handler
+}
+
+// False if there is no debugger to announce file, then the frontend cannot open it.
+func (p *proxy) announceScript(targetID string, file string) bool {
+    p.evalScopeHandlerMux.RLock()
+    handler := p.scriptHandler
+    p.evalScopeHandlerMux.RUnlock()
+    if handler == nil {
+        return false
+    }
+    handler(targetID, file)
+    return true
+}
+
+func (p *proxy) evalScope(targetID string) (dbgClient.EvalScope, error) {
+    p.evalScopeHandlerMux.RLock()
+    handler := p.evalScopeHandler
+    p.evalScopeHandlerMux.RUnlock()
+    if handler == nil {
+        return dbgClient.EvalScope{}, fmt.Errorf("The debugger is not enabled yet.")
+    }
+    return handler(targetID)
+}
+
+func exceptionDetails(err error) *runtimeAgent.ExceptionDetails {
+    description := err.Error()
+    subtype := runtimeAgent.RemoteObjectSubtypeError
+    return &runtimeAgent.ExceptionDetails{
+        ExceptionId: 1,
+        Text: err.Error(),
+        LineNumber: -1,
+        ColumnNumber: -1,
+        Exception: &runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeObject,
+            Subtype: &subtype,
+            Description: &description,
+        },
+    }
+}
+
+// Errors from delve are shown in the console like thrown js errors.
+func respondWithException(command runtimeAgent.EvaluateCommand, err error) {
+    details := exceptionDetails(err)
+    command.Respond(&runtimeAgent.EvaluateReturn{
+        Result: *details.Exception,
+        ExceptionDetails: details,
+    })
+}
+
+// Go value as plain json values, for returnByValue.
+func (p *proxy) jsonValue(variable dbgClient.Variable) interface{} {
+    switch variable.Kind {
+    case reflect.Struct, reflect.Array, reflect.Slice, reflect.Interface:
+        if description, ok := p.format(variable); ok {
+            return description
+        }
+    }
+    switch variable.Kind {
+    case reflect.Bool:
+        return variable.Value == "true"
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8,
+         reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64:
+        if number, err := strconv.ParseFloat(variable.Value, 64); err == nil {
+            return number
+        }
+        return variable.Value
+    case reflect.String:
+        return variable.Value
+    case reflect.Struct:
+        fields := map[string]interface{}{}
+        for _, field := range children(variable) {
+            fields[field.Name] = p.jsonValue(field)
+        }
+        return fields
+    case reflect.Array, reflect.Slice:
+        if isNil(variable) {
+            return nil
+        }
+        elements := []interface{}{}
+        for _, element := range children(variable) {
+            elements = append(elements, p.jsonValue(element))
+        }
+        return elements
+    case reflect.Map:
+        if isNil(variable) {
+            return nil
+        }
+        entries := map[string]interface{}{}
+        pairs := children(variable)
+        for i := 0; i + 1 < len(pairs); i += 2 {
+            key := pairs[i].Value
+            if pairs[i].Kind != reflect.String {
+                // Json only has string keys.
+                key = p.describeVariable(pairs[i])
+            }
+            entries[key] = p.jsonValue(pairs[i + 1])
+        }
+        return entries
+    case reflect.Ptr, reflect.Interface:
+        if isNil(variable) {
+            return nil
+        }
+        return p.jsonValue(children(variable)[0])
+    }
+    return p.describeVariable(variable)
+}
+
+func (p *proxy) valueRemoteObject(variable dbgClient.Variable) runtimeAgent.RemoteObject {
+    outKind, _ := remoteObjectType(variable)
+    return runtimeAgent.RemoteObject{
+        Type: outKind,
+        Value: p.jsonValue(variable),
+    }
+}
+
+func (p *proxy) evaluateAndRespond(command runtimeAgent.EvaluateCommand) {
+    throwOnSideEffect := command.ThrowOnSideEffect != nil && *command.ThrowOnSideEffect
+    isCommand := consoleCommandPattern.MatchString(command.Expression)
+    if input, ok := stdinInput(command, p.Running() && !isCommand); ok {
+        p.sendStdinAndRespond(command, input)
+        return
+    }
+    if isCommand {
+        scope := func() (dbgClient.EvalScope, error) {
+            return p.evalScope(command.DestinationTargetID)
+        }
+        result, _, err := p.consoleCommand(command.Expression, throwOnSideEffect, scope)
+        if err != nil {
+            respondWithException(command, err)
+            return
+        }
+        command.Respond(&runtimeAgent.EvaluateReturn{
+            Result: result,
+        })
+        return
+    }
+    if globalThisPattern.MatchString(command.Expression) {
+        command.Respond(&runtimeAgent.EvaluateReturn{
+            Result: p.globalObject(),
+        })
+        return
+    }
+    scope, err := p.evalScope(command.DestinationTargetID)
+    if err != nil {
+        respondWithException(command, err)
+        return
+    }
+    if asJSON, argument, ok := parseCopyCommand(command.Expression); ok {
+        if throwOnSideEffect {
+            respondWithException(command, fmt.Errorf("copy() is not run in previews, it writes the clipboard."))
+            return
+        }
+        if err := p.copyCommand(scope, asJSON, argument); err != nil {
+            respondWithException(command, err)
+            return
+        }
+        command.Respond(&runtimeAgent.EvaluateReturn{
+            Result: undefinedObject(),
+        })
+        return
+    }
+    returnByValue := command.ReturnByValue != nil && *command.ReturnByValue
+    cfg := p.LoadConfig()
+    if returnByValue {
+        cfg.MaxVariableRecurse = returnByValueRecurse
+    }
+    objectGroup := ""
+    if command.ObjectGroup != nil {
+        objectGroup = *command.ObjectGroup
+    }
+    variable, ref, err := p.evaluate(scope, command.Expression, objectGroup, throwOnSideEffect, cfg)
+    if err != nil {
+        respondWithException(command, err)
+        return
+    }
+    if returnByValue {
+        command.Respond(&runtimeAgent.EvaluateReturn{
+            Result: p.valueRemoteObject(*variable),
+        })
+        return
+    }
+    command.Respond(&runtimeAgent.EvaluateReturn{
+        Result: p.makeRemoteObject(*variable, ref),
+    })
+}
@@ -0,0 +1,490 @@
+package runtime
+
+import (
+    "bytes"
+    "fmt"
+    "math/big"
+    "net"
+    "net/netip"
+    "reflect"
+    "strconv"
+    "strings"
+    "text/template"
+    "time"
+    "github.com/allada/gdd/config"
+    "github.com/allada/gdd/dbgClient"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// Most wrapped errors followed when showing the unwrap chain of an error.
+const maxErrorChain = 10
+
+// Turns a loaded value of a well known type into something readable. Returns false if the value is not loaded far
+// enough to tell, in which case the value is described like any other.
+type formatter func(p *proxy, variable dbgClient.Variable) (string, bool)
+
+// Formatters for types everybody uses, by full type name. Filled in init since some formatters describe the values
+// they hold, which goes through this map again.
+var builtinFormatters map[string]formatter
+
+func init() {
+    builtinFormatters = map[string]formatter{
+        "time.Time": formatTime,
+        "time.Duration": formatDuration,
+        "math/big.Int": formatBigInt,
+        "net.IP": formatIP,
+        "net/netip.Addr": formatNetipAddr,
+        "github.com/google/uuid.UUID": formatUUID,
+        "github.com/gofrs/uuid.UUID": formatUUID,
+        "github.com/satori/go.uuid.UUID": formatUUID,
+        "sync.Mutex": formatMutex,
+        "sync.RWMutex": formatRWMutex,
+        "sync.WaitGroup": formatWaitGroup,
+        "sync/atomic.Bool": formatAtomicBool,
+        "sync/atomic.Int32": formatAtomicNumber,
+        "sync/atomic.Int64": formatAtomicNumber,
+        "sync/atomic.Uint32": formatAtomicNumber,
+        "sync/atomic.Uint64": formatAtomicNumber,
+        "sync/atomic.Uintptr": formatAtomicNumber,
+        "sync/atomic.Value": formatAtomicValue,
+    }
+}
+
+// Formatters for generic types, by type name prefix.
+var builtinPrefixFormatters = map[string]formatter{
+    "sync/atomic.Pointer[": formatAtomicPointer,
+}
+
+// Compiles the formatters users configured for their own types. They take precedence over the builtin ones.
+func (p *proxy) SetFormatters(formatters []config.Formatter) error {
+    templates := map[string]*template.Template{}
+    for _, formatter := range formatters {
+        tmpl, err := template.New(formatter.Type).Option("missingkey=zero").Parse(formatter.Template)
+        if err != nil {
+            return err
+        }
+        templates[formatter.Type] = tmpl
+    }
+    p.formattersMux.Lock()
+    defer p.formattersMux.Unlock()
+    p.userFormatters = templates
+    return nil
+}
+
+// Devtools calls this when its custom formatters setting changes. We use it to turn our formatters on and off, off
+// shows the raw fields of every value.
+func (p *proxy) setCustomObjectFormatterEnabledAndRespond(command runtimeAgent.SetCustomObjectFormatterEnabledCommand) {
+    p.formattersMux.Lock()
+    p.formattersEnabled = command.Enabled
+    p.formattersMux.Unlock()
+    command.Respond()
+}
+
+// Readable description of variable if there is a formatter for its type.
+func (p *proxy) format(variable dbgClient.Variable) (string, bool) {
+    if variable.Unreadable != "" {
+        return "", false
+    }
+    p.formattersMux.RLock()
+    enabled := p.formattersEnabled
+    tmpl := p.userFormatters[variable.Type]
+    p.formattersMux.RUnlock()
+    if !enabled {
+        return "", false
+    }
+    if tmpl != nil {
+        return p.formatWithTemplate(tmpl, variable)
+    }
+    if variable.Kind == reflect.Interface && variable.Type == "error" {
+        return p.formatError(variable)
+    }
+    if fn, ok := builtinFormatters[variable.Type]; ok {
+        return fn(p, variable)
+    }
+    for prefix, fn := range builtinPrefixFormatters {
+        if strings.HasPrefix(variable.Type, prefix) {
+            return fn(p, variable)
+        }
+    }
+    return "", false
+}
+
+func (p *proxy) formatWithTemplate(tmpl *template.Template, variable dbgClient.Variable) (string, bool) {
+    fields := map[string]string{}
+    if variable.Kind == reflect.Struct {
+        for _, field := range children(variable) {
+            fields[field.Name] = p.templateValue(field)
+        }
+    } else {
+        fields["Value"] = p.templateValue(variable)
+    }
+    var out bytes.Buffer
+    if err := tmpl.Execute(&out, fields); err != nil {
+        return "", false
+    }
+    return out.String(), true
+}
+
+// Strings go into templates without quotes, everything else as it would be described.
+func (p *proxy) templateValue(variable dbgClient.Variable) string {
+    if variable.Kind == reflect.String {
+        return variable.Value
+    }
+    return p.shortDescribeVariable(variable)
+}
+
+// Walks into nested fields, looking through the atomic wrappers newer go versions put around runtime fields.
+func fieldPath(variable dbgClient.Variable, names ...string) (dbgClient.Variable, bool) {
+    for _, name := range names {
+        child, ok := childByName(variable, name)
+        if !ok {
+            return dbgClient.Variable{}, false
+        }
+        variable = child
+    }
+    if variable.Kind == reflect.Struct && strings.HasPrefix(variable.Type, "sync/atomic.") {
+        return childByName(variable, "v")
+    }
+    return variable, true
+}
+
+func fieldUint(variable dbgClient.Variable, names ...string) (uint64, bool) {
+    field, ok := fieldPath(variable, names...)
+    if !ok {
+        return 0, false
+    }
+    number, err := strconv.ParseUint(field.Value, 10, 64)
+    return number, err == nil
+}
+
+func fieldInt(variable dbgClient.Variable, names ...string) (int64, bool) {
+    field, ok := fieldPath(variable, names...)
+    if !ok {
+        return 0, false
+    }
+    number, err := strconv.ParseInt(field.Value, 10, 64)
+    return number, err == nil
+}
+
+// Bytes of a []byte or [N]byte, if all of them are loaded.
+func byteValues(variable dbgClient.Variable) ([]byte, bool) {
+    elements := children(variable)
+    if int64(len(elements)) != variable.Len {
+        return nil, false
+    }
+    data := make([]byte, len(elements))
+    for i, element := range elements {
+        value, err := strconv.ParseUint(element.Value, 10, 8)
+        if err != nil {
+            return nil, false
+        }
+        data[i] = byte(value)
+    }
+    return data, true
+}
+
+// Same constants as the time package uses to pack a time.Time in wall and ext.
+const (
+    timeHasMonotonic = 1 << 63
+    timeNsecMask = 1 << 30 - 1
+    timeNsecShift = 30
+    timeWallToInternal int64 = (1884 * 365 + 1884 / 4 - 1884 / 100 + 1884 / 400) * 86400
+    timeUnixToInternal int64 = (1969 * 365 + 1969 / 4 - 1969 / 100 + 1969 / 400) * 86400
+)
+
+func formatTime(p *proxy, variable dbgClient.Variable) (string, bool) {
+    wall, ok := fieldUint(variable, "wall")
+    if !ok {
+        return "", false
+    }
+    ext, ok := fieldInt(variable, "ext")
+    if !ok {
+        return "", false
+    }
+    seconds := ext
+    if wall & timeHasMonotonic != 0 {
+        seconds = timeWallToInternal + int64(wall << 1 >> (timeNsecShift + 1))
+    }
+    t := time.Unix(seconds - timeUnixToInternal, int64(wall & timeNsecMask)).UTC()
+    // We only know the name of the location, its rules are looked up on this machine.
+    if loc, ok := childByName(variable, "loc"); ok && pointerValue(loc) != 0 {
+        if name, ok := fieldPath(children(loc)[0], "name"); ok && name.Value != "" {
+            if location, err := time.LoadLocation(name.Value); err == nil {
+                t = t.In(location)
+            } else {
+                return t.Format(time.RFC3339Nano) + " " + name.Value, true
+            }
+        }
+    }
+    return t.Format(time.RFC3339Nano), true
+}
+
+func formatDuration(p *proxy, variable dbgClient.Variable) (string, bool) {
+    nanoseconds, err := strconv.ParseInt(variable.Value, 10, 64)
+    if err != nil {
+        return "", false
+    }
+    return time.Duration(nanoseconds).String(), true
+}
+
+func formatBigInt(p *proxy, variable dbgClient.Variable) (string, bool) {
+    abs, ok := childByName(variable, "abs")
+    if !ok || int64(len(abs.Children)) != abs.Len {
+        return "", false
+    }
+    // abs holds the words of the number, least significant first. Assumes a 64 bit debugee.
+    value := new(big.Int)
+    words := children(abs)
+    for i := len(words) - 1; i >= 0; i-- {
+        word, err := strconv.ParseUint(words[i].Value, 10, 64)
+        if err != nil {
+            return "", false
+        }
+        value.Lsh(value, 64)
+        value.Or(value, new(big.Int).SetUint64(word))
+    }
+    if negative, ok := childByName(variable, "neg"); ok && negative.Value == "true" {
+        value.Neg(value)
+    }
+    return value.String(), true
+}
+
+func formatIP(p *proxy, variable dbgClient.Variable) (string, bool) {
+    data, ok := byteValues(variable)
+    if !ok {
+        return "", false
+    }
+    return net.IP(data).String(), true
+}
+
+func formatNetipAddr(p *proxy, variable dbgClient.Variable) (string, bool) {
+    hi, ok := fieldUint(variable, "addr", "hi")
+    if !ok {
+        return "", false
+    }
+    lo, ok := fieldUint(variable, "addr", "lo")
+    if !ok {
+        return "", false
+    }
+    if z, ok := childByName(variable, "z"); ok && pointerValue(z) == 0 {
+        return "invalid IP", true
+    }
+    // IPv4 addresses are kept as IPv4 mapped IPv6 addresses. We cannot see the zone pointer that tells the two
+    // apart, so a real mapped address shows as IPv4 too.
+    if hi == 0 && lo >> 32 == 0xffff {
+        return netip.AddrFrom4([4]byte{byte(lo >> 24), byte(lo >> 16), byte(lo >> 8), byte(lo)}).String(), true
+    }
+    var data [16]byte
+    for i := 0; i < 8; i++ {
+        data[i] = byte(hi >> uint(56 - 8 * i))
+        data[i + 8] = byte(lo >> uint(56 - 8 * i))
+    }
+    return netip.AddrFrom16(data).String(), true
+}
+
+func formatUUID(p *proxy, variable dbgClient.Variable) (string, bool) {
+    data, ok := byteValues(variable)
+    if !ok || len(data) != 16 {
+        return "", false
+    }
+    return fmt.Sprintf("%x-%x-%x-%x-%x", data[0:4], data[4:6], data[6:8], data[8:10], data[10:16]), true
+}
+
+// Mutex state bits, from sync/mutex.go.
+const (
+    mutexLocked = 1
+    mutexStarving = 4
+    mutexWaiterShift = 3
+    rwmutexMaxReaders = 1 << 30
+)
+
+// Newer go versions keep the mutex in a mu field.
+func mutexState(variable dbgClient.Variable) (int64, bool) {
+    if state, ok := fieldInt(variable, "state"); ok {
+        return state, true
+    }
+    return fieldInt(variable, "mu", "state")
+}
+
+func describeMutexState(state int64) string {
+    description := "unlocked"
+    if state & mutexLocked != 0 {
+        description = "locked"
+    }
+    if waiters := state >> mutexWaiterShift; waiters > 0 {
+        description += fmt.Sprintf(" (%d waiting)", waiters)
+    }
+    if state & mutexStarving != 0 {
+        description += " starving"
+    }
+    return description
+}
+
+func formatMutex(p *proxy, variable dbgClient.Variable) (string, bool) {
+    state, ok := mutexState(variable)
+    if !ok {
+        return "", false
+    }
+    return describeMutexState(state), true
+}
+
+func formatRWMutex(p *proxy, variable dbgClient.Variable) (string, bool) {
+    readers, ok := fieldInt(variable, "readerCount")
+    if !ok {
+        return "", false
+    }
+    writer := false
+    if w, ok := childByName(variable, "w"); ok {
+        if state, ok := mutexState(w); ok {
+            writer = state & mutexLocked != 0
+        }
+    }
+    // A waiting or active writer takes rwmutexMaxReaders off the reader count.
+    if readers < 0 {
+        readers += rwmutexMaxReaders
+        writer = true
+    }
+    switch {
+    case writer && readers > 0:
+        return fmt.Sprintf("writer waiting for %d readers", readers), true
+    case writer:
+        return "write locked", true
+    case readers > 0:
+        return fmt.Sprintf("read locked by %d readers", readers), true
+    }
+    return "unlocked", true
+}
+
+func formatWaitGroup(p *proxy, variable dbgClient.Variable) (string, bool) {
+    state, ok := fieldUint(variable, "state")
+    if !ok {
+        // Go 1.18 and 1.19 called it state1.
+        if state, ok = fieldUint(variable, "state1"); !ok {
+            return "", false
+        }
+    }
+    return fmt.Sprintf("counter: %d, waiters: %d", int32(state >> 32), uint32(state)), true
+}
+
+func formatAtomicBool(p *proxy, variable dbgClient.Variable) (string, bool) {
+    value, ok := fieldUint(variable, "v")
+    if !ok {
+        return "", false
+    }
+    return strconv.FormatBool(value != 0), true
+}
+
+func formatAtomicNumber(p *proxy, variable dbgClient.Variable) (string, bool) {
+    value, ok := childByName(variable, "v")
+    if !ok {
+        return "", false
+    }
+    return value.Value, true
+}
+
+func formatAtomicValue(p *proxy, variable dbgClient.Variable) (string, bool) {
+    value, ok := childByName(variable, "v")
+    if !ok {
+        return "", false
+    }
+    if isNil(value) {
+        return "nil", true
+    }
+    return p.shortDescribeVariable(value), true
+}
+
+func formatAtomicPointer(p *proxy, variable dbgClient.Variable) (string, bool) {
+    value, ok := childByName(variable, "v")
+    if !ok {
+        return "", false
+    }
+    if isNil(value) {
+        return "nil", true
+    }
+    return fmt.Sprintf("%#x", pointerValue(value)), true
+}
+
+// Fields errors from the standard library keep their message and the error they wrap in.
+var errorMessageFields = []string{"msg", "s"}
+var wrappedErrorFields = []string{"err", "Err"}
+
+// Message and wrapped error of the value inside an error interface, if we know where to find them.
+func errorParts(data dbgClient.Variable) (string, dbgClient.Variable, bool) {
+    if data.Kind == reflect.Ptr && isComposite(data) {
+        data = children(data)[0]
+    }
+    message := ""
+    for _, name := range errorMessageFields {
+        if field, ok := childByName(data, name); ok && field.Kind == reflect.String {
+            message = field.Value
+            break
+        }
+    }
+    for _, name := range wrappedErrorFields {
+        if field, ok := childByName(data, name); ok && field.Kind == reflect.Interface && !isNil(field) {
+            return message, field, true
+        }
+    }
+    return message, dbgClient.Variable{}, false
+}
+
+// A wrapped error loaded again through its pointer. Values are formatted more than once, for the preview, the
+// description and so on, so the result is kept until the program runs again. Failures are kept too.
+func (p *proxy) reloadError(pointer dbgClient.Variable) (dbgClient.Variable, bool) {
+    expression := fmt.Sprintf("(%s)(%#x)", typeExpression(pointer.Type), pointerValue(pointer))
+    p.reloadedErrorsMux.Lock()
+    defer p.reloadedErrorsMux.Unlock()
+    if reloaded, ok := p.reloadedErrors[expression]; ok {
+        return reloaded, reloaded.Kind != reflect.Invalid
+    }
+    reloaded, err := p.client.EvalVariable(dbgClient.EvalScope{GoroutineID: -1}, expression, p.LoadConfig())
+    if err != nil {
+        p.reloadedErrors[expression] = dbgClient.Variable{}
+        return dbgClient.Variable{}, false
+    }
+    p.reloadedErrors[expression] = *reloaded
+    return *reloaded, true
+}
+
+func (p *proxy) forgetReloadedErrors() {
+    p.reloadedErrorsMux.Lock()
+    defer p.reloadedErrorsMux.Unlock()
+    p.reloadedErrors = map[string]dbgClient.Variable{}
+}
+
+// Shows an error as its message and the chain of errors it wraps, like
+// "open x: no such file [*fs.PathError → syscall.Errno]".
+func (p *proxy) formatError(variable dbgClient.Variable) (string, bool) {
+    if isNil(variable) {
+        return "", false
+    }
+    message := ""
+    chain := []string{}
+    current := variable
+    for len(chain) < maxErrorChain {
+        data := children(current)[0]
+        if len(chain) > 0 && data.Kind == reflect.Ptr && pointerValue(data) != 0 {
+            // Wrapped errors are usually deeper than values are loaded, we load them again through their pointer.
+            if reloaded, ok := p.reloadError(data); ok {
+                data = reloaded
+            }
+        }
+        chain = append(chain, data.Type)
+        partMessage, wrapped, ok := errorParts(data)
+        if message == "" {
+            message = partMessage
+        }
+        if !ok {
+            break
+        }
+        current = wrapped
+    }
+    description := variable.Type + "(" + chain[0] + ")"
+    if message != "" {
+        description += " " + strconv.Quote(message)
+    }
+    if len(chain) > 1 {
+        description += " [" + strings.Join(chain[1:], " → ") + "]"
+    }
+    return description, true
+}
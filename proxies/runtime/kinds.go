@@ -0,0 +1,242 @@
+package runtime
+
+import (
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+    "github.com/allada/gdd/dbgClient"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// Most goroutines listed from one of a channel's wait queues, so a corrupt queue does not hang us.
+const maxChanWaiters = 100
+
+func childByName(variable dbgClient.Variable, name string) (dbgClient.Variable, bool) {
+    for _, child := range children(variable) {
+        if child.Name == name {
+            return child, true
+        }
+    }
+    return dbgClient.Variable{}, false
+}
+
+func childInt(variable dbgClient.Variable, name string) int64 {
+    child, ok := childByName(variable, name)
+    if !ok {
+        return 0
+    }
+    number, _ := strconv.ParseInt(child.Value, 0, 64)
+    return number
+}
+
+// Value of a pointer or unsafe.Pointer, delve keeps it as the address of the thing pointed at.
+func pointerValue(variable dbgClient.Variable) uint64 {
+    if len(variable.Children) == 0 {
+        return 0
+    }
+    return uint64(variable.Children[0].Addr)
+}
+
+// Go formats complex numbers as (1+2i), delve gives us the two parts.
+func complexValue(variable dbgClient.Variable) string {
+    realPart, realOk := childByName(variable, "real")
+    imagPart, imagOk := childByName(variable, "imaginary")
+    if !realOk || !imagOk {
+        return variable.Value
+    }
+    // Parts of a complex64 are float32, printing them as float64 would show digits that are not there.
+    bitSize := 64
+    if variable.Kind == reflect.Complex64 {
+        bitSize = 32
+    }
+    r, err := strconv.ParseFloat(realPart.Value, bitSize)
+    if err != nil {
+        return variable.Value
+    }
+    i, err := strconv.ParseFloat(imagPart.Value, bitSize)
+    if err != nil {
+        return variable.Value
+    }
+    if bitSize == 32 {
+        return fmt.Sprint(complex(float32(r), float32(i)))
+    }
+    return fmt.Sprint(complex(r, i))
+}
+
+// What delve loads for a channel is its runtime.hchan.
+type chanInfo struct {
+    Len int64
+    Cap int64
+    Closed bool
+    Buf uint64
+    RecvX int64
+}
+
+func loadChanInfo(variable dbgClient.Variable) chanInfo {
+    info := chanInfo{
+        Len: variable.Len,
+        Cap: variable.Cap,
+    }
+    if _, ok := childByName(variable, "qcount"); ok {
+        info.Len = childInt(variable, "qcount")
+        info.Cap = childInt(variable, "dataqsiz")
+        info.Closed = childInt(variable, "closed") != 0
+        info.RecvX = childInt(variable, "recvx")
+        if buf, ok := childByName(variable, "buf"); ok {
+            info.Buf = pointerValue(buf)
+        }
+    }
+    return info
+}
+
+func chanElementType(chanType string) string {
+    for _, prefix := range []string{"chan<- ", "<-chan ", "chan "} {
+        if strings.HasPrefix(chanType, prefix) {
+            return chanType[len(prefix):]
+        }
+    }
+    return chanType
+}
+
+func describeChan(variable dbgClient.Variable) string {
+    if variable.Base == 0 && len(variable.Children) == 0 {
+        return variable.Type + " nil"
+    }
+    info := loadChanInfo(variable)
+    description := fmt.Sprintf("%s len:%d cap:%d", variable.Type, info.Len, info.Cap)
+    if info.Closed {
+        description += " closed"
+    }
+    return description
+}
+
+func syntheticProperty(name string, value string) runtimeAgent.PropertyDescriptor {
+    return runtimeAgent.PropertyDescriptor{
+        Name: name,
+        Value: &runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeString,
+            Value: value,
+        },
+        Enumerable: true,
+    }
+}
+
+// Ids of the goroutines parked in one of the wait queues (recvq or sendq) of a channel.
+func (p *proxy) chanWaiters(ref objectRef, queue string) []string {
+    waiters := []string{}
+    expression := fmt.Sprintf("%s.%s.first", parenthesize(ref.Expression), queue)
+    for len(waiters) < maxChanWaiters {
+        sudog, err := p.client.EvalVariable(ref.Scope, expression, p.LoadConfig())
+        if err != nil || pointerValue(*sudog) == 0 {
+            break
+        }
+        address := fmt.Sprintf("(*runtime.sudog)(%#x)", pointerValue(*sudog))
+        goroutine, err := p.client.EvalVariable(ref.Scope, address + ".g.goid", p.LoadConfig())
+        if err != nil {
+            break
+        }
+        waiters = append(waiters, goroutine.Value)
+        expression = address + ".next"
+    }
+    return waiters
+}
+
+// Lists a channel's state, the values buffered in it in receive order, and who is waiting on it.
+func (p *proxy) expandChan(variable dbgClient.Variable, ref objectRef) []runtimeAgent.PropertyDescriptor {
+    info := loadChanInfo(variable)
+    properties := []runtimeAgent.PropertyDescriptor{
+        syntheticProperty("len", strconv.FormatInt(info.Len, 10)),
+        syntheticProperty("cap", strconv.FormatInt(info.Cap, 10)),
+        syntheticProperty("closed", strconv.FormatBool(info.Closed)),
+    }
+    if info.Buf != 0 && info.Cap > 0 {
+        buffer := fmt.Sprintf("(*(*[%d]%s)(%#x))", info.Cap, typeExpression(chanElementType(variable.Type)), info.Buf)
+        maxValues := int64(p.LoadLimits().MaxArrayValues)
+        for i := int64(0); i < info.Len && i < maxValues; i++ {
+            expression := fmt.Sprintf("%s[%d]", buffer, (info.RecvX + i) % info.Cap)
+            element, err := p.client.EvalVariable(ref.Scope, expression, p.LoadConfig())
+            if err != nil {
+                break
+            }
+            properties = append(properties, p.makeProperty(strconv.FormatInt(i, 10), *element, childRef(ref, expression)))
+        }
+    }
+    for _, queue := range []string{"recvq", "sendq"} {
+        if waiters := p.chanWaiters(ref, queue); len(waiters) > 0 {
+            properties = append(properties, syntheticProperty(queue, "goroutines " + strings.Join(waiters, ", ")))
+        }
+    }
+    return properties
+}
+
+// Where a function starts. Functions do not move, so lookups are cached.
+func (p *proxy) functionLocation(name string) (dbgClient.Location, bool) {
+    // Method values are wrappers named after the method with -fm on the end, the method is what we want to see.
+    name = strings.TrimSuffix(name, "-fm")
+    p.functionLocationsMux.Lock()
+    location, ok := p.functionLocations[name]
+    p.functionLocationsMux.Unlock()
+    if !ok {
+        if locations, err := p.client.FindLocation(dbgClient.EvalScope{GoroutineID: -1}, name); err == nil && len(locations) > 0 {
+            location = locations[0]
+        }
+        p.functionLocationsMux.Lock()
+        p.functionLocations[name] = location
+        p.functionLocationsMux.Unlock()
+    }
+    return location, location.File != ""
+}
+
+// Name and source location of the function a func value points at.
+func (p *proxy) describeFunction(variable dbgClient.Variable) string {
+    if variable.Value == "" {
+        return variable.Type + " nil"
+    }
+    name := variable.Value
+    location, ok := p.functionLocation(name)
+    if !ok {
+        return "func " + name
+    }
+    return fmt.Sprintf("func %s (%s:%d)", name, location.File, location.Line)
+}
+
+// Lists the variables a closure captured. Delve loads them as children of the func value.
+func (p *proxy) expandFunction(variable dbgClient.Variable, ref objectRef) []runtimeAgent.PropertyDescriptor {
+    properties := []runtimeAgent.PropertyDescriptor{}
+    for _, captured := range children(variable) {
+        var capturedRef *objectRef
+        if captured.Addr != 0 {
+            capturedRef = childRef(ref, addressExpression(captured))
+        }
+        properties = append(properties, p.makeProperty(captured.Name, captured, capturedRef))
+    }
+    return properties
+}
+
+// Devtools shows "go to function definition" for functions with this internal property.
+func (p *proxy) functionLocationInternal(variable dbgClient.Variable, targetID string) (runtimeAgent.InternalPropertyDescriptor, bool) {
+    location, ok := p.functionLocation(variable.Value)
+    if variable.Value == "" || !ok {
+        return runtimeAgent.InternalPropertyDescriptor{}, false
+    }
+    // The location is only a link if the frontend has the script it points into.
+    if location.File == "<autogenerated>" || !p.announceScript(targetID, location.File) {
+        return runtimeAgent.InternalPropertyDescriptor{}, false
+    }
+    subtype := runtimeAgent.RemoteObjectSubtypeEnum("internal#location")
+    description := "Object"
+    return runtimeAgent.InternalPropertyDescriptor{
+        Name: "[[FunctionLocation]]",
+        Value: &runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeObject,
+            Subtype: &subtype,
+            Description: &description,
+            Value: map[string]interface{}{
+                "scriptId": location.File,
+                "lineNumber": location.Line - 1,
+                "columnNumber": 0,
+            },
+        },
+    }, true
+}
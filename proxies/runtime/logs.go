@@ -0,0 +1,368 @@
+package runtime
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "github.com/allada/gdd/protocol/shared"
+    logAgent "github.com/allada/gdd/protocol/log"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+const logObjectIdPrefix = "log:"
+
+// Values of parsed log lines we keep around so they can be expanded. Older ones are dropped first.
+const maxLogObjects = 10000
+
+// Keys loggers put the message and level under. zap, logrus and slog between them use all of these.
+var logMessageKeys = []string{"msg", "message"}
+var logLevelKeys = []string{"level", "lvl", "severity"}
+
+type logField struct {
+    Key string
+    Value interface{} // Anything encoding/json decodes to.
+}
+
+// One structured log line.
+type logLine struct {
+    Level string
+    Message string
+    Fields []logField
+}
+
+// Recognizes log lines of some format. Parsers are tried in order, the first one that understands a line wins.
+type logParser interface {
+    Parse(line string) (logLine, bool)
+}
+
+var logParsers = []logParser{
+    jsonLogParser{},
+    logfmtLogParser{},
+}
+
+// Picks the message and level out of the fields.
+func newLogLine(fields []logField) logLine {
+    line := logLine{}
+    rest := []logField{}
+    for _, field := range fields {
+        if line.Message == "" && containsKey(logMessageKeys, field.Key) {
+            line.Message = fmt.Sprint(field.Value)
+            continue
+        }
+        if line.Level == "" && containsKey(logLevelKeys, field.Key) {
+            line.Level = fmt.Sprint(field.Value)
+            continue
+        }
+        rest = append(rest, field)
+    }
+    line.Fields = rest
+    return line
+}
+
+func containsKey(keys []string, key string) bool {
+    for _, k := range keys {
+        if strings.EqualFold(k, key) {
+            return true
+        }
+    }
+    return false
+}
+
+// Lines like {"level":"info","msg":"started","port":8080}.
+type jsonLogParser struct{}
+
+func (jsonLogParser) Parse(line string) (logLine, bool) {
+    if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+        return logLine{}, false
+    }
+    // Numbers stay as written, ids and nanosecond timestamps do not fit in a float64.
+    decoder := json.NewDecoder(strings.NewReader(line))
+    decoder.UseNumber()
+    var object map[string]interface{}
+    if err := decoder.Decode(&object); err != nil || decoder.More() {
+        return logLine{}, false
+    }
+    // Json objects have no order, so the fields are sorted to at least be stable.
+    keys := []string{}
+    for key := range object {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    fields := []logField{}
+    for _, key := range keys {
+        fields = append(fields, logField{key, object[key]})
+    }
+    return newLogLine(fields), true
+}
+
+// Lines like level=info msg="started server" port=8080. Only lines with a level are taken, plain text has too many
+// equal signs in it otherwise.
+type logfmtLogParser struct{}
+
+func (logfmtLogParser) Parse(line string) (logLine, bool) {
+    fields := []logField{}
+    rest := strings.TrimSpace(line)
+    for rest != "" {
+        equals := strings.IndexByte(rest, '=')
+        if equals <= 0 || strings.ContainsAny(rest[:equals], " \t\"") {
+            return logLine{}, false
+        }
+        key := rest[:equals]
+        rest = rest[equals + 1:]
+        value := ""
+        if strings.HasPrefix(rest, "\"") {
+            end := 1
+            for ; end < len(rest) && rest[end] != '"'; end++ {
+                if rest[end] == '\\' {
+                    end++
+                }
+            }
+            if end >= len(rest) {
+                return logLine{}, false
+            }
+            unquoted, err := strconv.Unquote(rest[:end + 1])
+            if err != nil {
+                return logLine{}, false
+            }
+            value = unquoted
+            rest = rest[end + 1:]
+        } else if space := strings.IndexAny(rest, " \t"); space >= 0 {
+            value = rest[:space]
+            rest = rest[space:]
+        } else {
+            value = rest
+            rest = ""
+        }
+        fields = append(fields, logField{key, value})
+        rest = strings.TrimLeft(rest, " \t")
+    }
+    parsed := newLogLine(fields)
+    if parsed.Level == "" {
+        return logLine{}, false
+    }
+    return parsed, true
+}
+
+// Console type and Log domain level for a log level, however the logger spells it.
+func logLevels(level string) (runtimeAgent.ConsoleAPICalledTypeEnum, logAgent.LogEntryLevelEnum, bool) {
+    switch strings.ToLower(level) {
+    case "error", "err", "fatal", "panic", "dpanic", "critical", "crit", "alert", "emergency":
+        return runtimeAgent.ConsoleAPICalledTypeError, logAgent.LogEntryLevelError, true
+    case "warn", "warning":
+        return runtimeAgent.ConsoleAPICalledTypeWarning, logAgent.LogEntryLevelWarning, true
+    case "info", "notice":
+        return runtimeAgent.ConsoleAPICalledTypeInfo, logAgent.LogEntryLevelInfo, true
+    case "debug", "trace":
+        return runtimeAgent.ConsoleAPICalledTypeDebug, logAgent.LogEntryLevelVerbose, true
+    }
+    return "", "", false
+}
+
+// Holds the decoded json of log lines, and other made up objects like struct tags, that the frontend may still expand.
+type logObjectStore struct {
+    mux sync.Mutex
+    nextID int
+    objects map[runtimeAgent.RemoteObjectId]interface{}
+    order []runtimeAgent.RemoteObjectId
+}
+
+func newLogObjectStore() *logObjectStore {
+    return &logObjectStore{
+        objects: map[runtimeAgent.RemoteObjectId]interface{}{},
+    }
+}
+
+func (s *logObjectStore) Add(value interface{}) runtimeAgent.RemoteObjectId {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    s.nextID++
+    id := runtimeAgent.RemoteObjectId(fmt.Sprintf("%s%d", logObjectIdPrefix, s.nextID))
+    s.objects[id] = value
+    s.order = append(s.order, id)
+    if len(s.order) > maxLogObjects {
+        delete(s.objects, s.order[0])
+        s.order = s.order[1:]
+    }
+    return id
+}
+
+func (s *logObjectStore) Get(id runtimeAgent.RemoteObjectId) (interface{}, bool) {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    value, ok := s.objects[id]
+    return value, ok
+}
+
+// Remote object for a decoded json value. Objects and arrays get an id so they can be expanded.
+func (p *proxy) jsonRemoteObject(value interface{}) runtimeAgent.RemoteObject {
+    switch value := value.(type) {
+    case string:
+        return runtimeAgent.RemoteObject{Type: runtimeAgent.RemoteObjectTypeString, Value: value}
+    case float64:
+        return runtimeAgent.RemoteObject{Type: runtimeAgent.RemoteObjectTypeNumber, Value: value}
+    case json.Number:
+        // The frontend shows the description, so numbers too big for a float64 still show every digit.
+        description := value.String()
+        number, _ := strconv.ParseFloat(description, 64)
+        return runtimeAgent.RemoteObject{Type: runtimeAgent.RemoteObjectTypeNumber, Value: number, Description: &description}
+    case bool:
+        return runtimeAgent.RemoteObject{Type: runtimeAgent.RemoteObjectTypeBoolean, Value: value}
+    case []logField:
+        return p.jsonObject(value, "Object", nil)
+    case map[string]interface{}:
+        keys := []string{}
+        for key := range value {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        fields := []logField{}
+        for _, key := range keys {
+            fields = append(fields, logField{key, value[key]})
+        }
+        return p.jsonObject(fields, "Object", nil)
+    case []interface{}:
+        fields := []logField{}
+        for i, element := range value {
+            fields = append(fields, logField{strconv.Itoa(i), element})
+        }
+        subtype := runtimeAgent.RemoteObjectSubtypeArray
+        return p.jsonObject(fields, fmt.Sprintf("Array(%d)", len(value)), &subtype)
+    }
+    subtype := runtimeAgent.RemoteObjectSubtypeNull
+    return runtimeAgent.RemoteObject{Type: runtimeAgent.RemoteObjectTypeObject, Subtype: &subtype, Value: nil}
+}
+
+func (p *proxy) jsonObject(fields []logField, description string, subtype *runtimeAgent.RemoteObjectSubtypeEnum) runtimeAgent.RemoteObject {
+    id := p.logObjects.Add(fields)
+    preview := &runtimeAgent.ObjectPreview{
+        Type: runtimeAgent.ObjectPreviewTypeObject,
+        Description: &description,
+        Overflow: len(fields) > previewMaxProperties,
+        Properties: []runtimeAgent.PropertyPreview{},
+    }
+    if subtype != nil {
+        previewSubtype := runtimeAgent.ObjectPreviewSubtypeEnum(*subtype)
+        preview.Subtype = &previewSubtype
+    }
+    for i, field := range fields {
+        if i == previewMaxProperties {
+            break
+        }
+        preview.Properties = append(preview.Properties, jsonPropertyPreview(field))
+    }
+    return runtimeAgent.RemoteObject{
+        Type: runtimeAgent.RemoteObjectTypeObject,
+        Subtype: subtype,
+        Description: &description,
+        ObjectId: &id,
+        Preview: preview,
+    }
+}
+
+func jsonPropertyPreview(field logField) runtimeAgent.PropertyPreview {
+    previewType := runtimeAgent.PropertyPreviewTypeObject
+    value := ""
+    switch fieldValue := field.Value.(type) {
+    case string:
+        previewType = runtimeAgent.PropertyPreviewTypeString
+        value = truncate(fieldValue, previewMaxValueLen)
+    case float64:
+        previewType = runtimeAgent.PropertyPreviewTypeNumber
+        value = strconv.FormatFloat(fieldValue, 'g', -1, 64)
+    case json.Number:
+        previewType = runtimeAgent.PropertyPreviewTypeNumber
+        value = fieldValue.String()
+    case bool:
+        previewType = runtimeAgent.PropertyPreviewTypeBoolean
+        value = strconv.FormatBool(fieldValue)
+    case nil:
+        value = "null"
+    case []interface{}:
+        value = fmt.Sprintf("Array(%d)", len(fieldValue))
+    default:
+        value = "Object"
+    }
+    return runtimeAgent.PropertyPreview{
+        Name: field.Key,
+        Type: previewType,
+        Value: &value,
+    }
+}
+
+func (p *proxy) getLogObjectPropertiesAndRespond(command runtimeAgent.GetPropertiesCommand) {
+    value, ok := p.logObjects.Get(command.ObjectId)
+    if !ok {
+        command.RespondWithError(shared.ErrorCodeInvalidParams, "Could not find object with given id")
+        return
+    }
+    properties := []runtimeAgent.PropertyDescriptor{}
+    for _, field := range value.([]logField) {
+        remoteObject := p.jsonRemoteObject(field.Value)
+        properties = append(properties, runtimeAgent.PropertyDescriptor{
+            Name: field.Key,
+            Value: &remoteObject,
+            Enumerable: true,
+        })
+    }
+    command.Respond(&runtimeAgent.GetPropertiesReturn{
+        Result: properties,
+    })
+}
+
+func (p *proxy) enableLogAndRespond(command logAgent.EnableCommand) {
+    atomic.StoreInt32(&p.logEnabled, 1)
+    command.Respond()
+}
+
+func (p *proxy) disableLogAndRespond(command logAgent.DisableCommand) {
+    atomic.StoreInt32(&p.logEnabled, 0)
+    command.Respond()
+}
+
+// Sends a parsed log line as message plus an expandable object of its fields. It goes to the Log domain, whose level
+// filters then work on it. Until the frontend enabled that domain the line goes to the console instead, never both.
+func (p *proxy) sendLogLine(consoleType runtimeAgent.ConsoleAPICalledTypeEnum, line logLine, raw string, stackTrace *runtimeAgent.StackTrace) {
+    level := logAgent.LogEntryLevelInfo
+    if consoleType == runtimeAgent.ConsoleAPICalledTypeError {
+        level = logAgent.LogEntryLevelError
+    }
+    if lineType, lineLevel, ok := logLevels(line.Level); ok {
+        consoleType = lineType
+        level = lineLevel
+    }
+    message := line.Message
+    if message == "" {
+        message = raw
+    }
+    args := []runtimeAgent.RemoteObject{
+        {
+            Type: runtimeAgent.RemoteObjectTypeString,
+            Value: message,
+        },
+        p.jsonRemoteObject(line.Fields),
+    }
+    if atomic.LoadInt32(&p.logEnabled) == 1 {
+        p.logAgent.FireEntryAdded(logAgent.EntryAddedEvent{
+            Entry: logAgent.LogEntry{
+                Source: logAgent.LogEntrySourceOther,
+                Level: level,
+                Text: message,
+                Timestamp: now(),
+                StackTrace: stackTrace,
+                Args: &args,
+            },
+        })
+        return
+    }
+    p.agent.FireConsoleAPICalled(runtimeAgent.ConsoleAPICalledEvent{
+        Type: consoleType,
+        Args: args,
+        Timestamp: now(),
+        ExecutionContextId: executionContextId,
+        StackTrace: stackTrace,
+    })
+}
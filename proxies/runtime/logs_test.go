@@ -0,0 +1,110 @@
+package runtime
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+    logAgent "github.com/allada/gdd/protocol/log"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+func TestJsonLogParser(t *testing.T) {
+    tests := []struct {
+        line string
+        ok bool
+        want logLine
+    }{
+        {
+            line: `{"level":"info","msg":"started","port":8080}`,
+            ok: true,
+            want: logLine{Level: "info", Message: "started", Fields: []logField{{"port", json.Number("8080")}}},
+        },
+        {
+            line: `{"severity":"ERROR","message":"failed","id":12345678901234567890}`,
+            ok: true,
+            want: logLine{Level: "ERROR", Message: "failed", Fields: []logField{{"id", json.Number("12345678901234567890")}}},
+        },
+        {
+            line: `  {"msg":"no level"}`,
+            ok: true,
+            want: logLine{Message: "no level", Fields: []logField{}},
+        },
+        {line: `{"level":"info"} trailing`},
+        {line: `{"level":`},
+        {line: `plain text`},
+        {line: `[1, 2]`},
+    }
+    for _, test := range tests {
+        got, ok := jsonLogParser{}.Parse(test.line)
+        if ok != test.ok {
+            t.Errorf("Parse(%q) ok = %v, want %v", test.line, ok, test.ok)
+            continue
+        }
+        if ok && !reflect.DeepEqual(got, test.want) {
+            t.Errorf("Parse(%q) = %#v, want %#v", test.line, got, test.want)
+        }
+    }
+}
+
+func TestLogfmtLogParser(t *testing.T) {
+    tests := []struct {
+        line string
+        ok bool
+        want logLine
+    }{
+        {
+            line: `level=info msg="started server" port=8080`,
+            ok: true,
+            want: logLine{Level: "info", Message: "started server", Fields: []logField{{"port", "8080"}}},
+        },
+        {
+            line: `lvl=warn msg="say \"hi\"" path=/a=b`,
+            ok: true,
+            want: logLine{Level: "warn", Message: `say "hi"`, Fields: []logField{{"path", "/a=b"}}},
+        },
+        {
+            line: `level=debug empty=`,
+            ok: true,
+            want: logLine{Level: "debug", Fields: []logField{{"empty", ""}}},
+        },
+        {line: `msg=hello port=8080`},
+        {line: `level=info msg="unterminated`},
+        {line: `the result is x=1`},
+        {line: `=value level=info`},
+        {line: ``},
+    }
+    for _, test := range tests {
+        got, ok := logfmtLogParser{}.Parse(test.line)
+        if ok != test.ok {
+            t.Errorf("Parse(%q) ok = %v, want %v", test.line, ok, test.ok)
+            continue
+        }
+        if ok && !reflect.DeepEqual(got, test.want) {
+            t.Errorf("Parse(%q) = %#v, want %#v", test.line, got, test.want)
+        }
+    }
+}
+
+func TestLogLevels(t *testing.T) {
+    tests := []struct {
+        level string
+        consoleType runtimeAgent.ConsoleAPICalledTypeEnum
+        logLevel logAgent.LogEntryLevelEnum
+        ok bool
+    }{
+        {"error", runtimeAgent.ConsoleAPICalledTypeError, logAgent.LogEntryLevelError, true},
+        {"FATAL", runtimeAgent.ConsoleAPICalledTypeError, logAgent.LogEntryLevelError, true},
+        {"Warning", runtimeAgent.ConsoleAPICalledTypeWarning, logAgent.LogEntryLevelWarning, true},
+        {"notice", runtimeAgent.ConsoleAPICalledTypeInfo, logAgent.LogEntryLevelInfo, true},
+        {"trace", runtimeAgent.ConsoleAPICalledTypeDebug, logAgent.LogEntryLevelVerbose, true},
+        {"verbose", "", "", false},
+        {"", "", "", false},
+    }
+    for _, test := range tests {
+        consoleType, logLevel, ok := logLevels(test.level)
+        if consoleType != test.consoleType || logLevel != test.logLevel || ok != test.ok {
+            t.Errorf("logLevels(%q) = %q, %q, %v, want %q, %q, %v", test.level, consoleType, logLevel, ok,
+                     test.consoleType, test.logLevel, test.ok)
+        }
+    }
+}
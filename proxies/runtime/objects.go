@@ -0,0 +1,296 @@
+package runtime
+
+import (
+    "fmt"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "github.com/allada/gdd/dbgClient"
+    "github.com/allada/gdd/protocol/shared"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+const objectIdPrefix = "obj:"
+
+// Everything we need to load a value from delve again. Values are found by evaluating Expression in the scope, which
+// is either a path from a variable (a.b[3]) or, if there is no path, a cast of the value's address.
+type objectRef struct {
+    Scope dbgClient.EvalScope
+    Expression string
+    Group string
+    // Range objects stand for the elements (or bytes of a string) in [RangeStart, RangeEnd) of Expression.
+    IsRange bool
+    RangeKind reflect.Kind
+    RangeStart int64
+    RangeEnd int64
+}
+
+// Hands out object ids for composite go values so the frontend can expand them.
+type objectRegistry struct {
+    mux sync.Mutex
+    nextID int
+    objects map[runtimeAgent.RemoteObjectId]objectRef
+    ids map[string]runtimeAgent.RemoteObjectId // "goroutine:frame:expression" -> id, so a value keeps one id.
+}
+
+func newObjectRegistry() *objectRegistry {
+    return &objectRegistry{
+        objects: map[runtimeAgent.RemoteObjectId]objectRef{},
+        ids: map[string]runtimeAgent.RemoteObjectId{},
+    }
+}
+
+func refKey(ref objectRef) string {
+    if ref.IsRange {
+        return fmt.Sprintf("%d:%d:%s[%d:%d]", ref.Scope.GoroutineID, ref.Scope.Frame, ref.Expression, ref.RangeStart, ref.RangeEnd)
+    }
+    return fmt.Sprintf("%d:%d:%s", ref.Scope.GoroutineID, ref.Scope.Frame, ref.Expression)
+}
+
+func (r *objectRegistry) Register(ref objectRef) runtimeAgent.RemoteObjectId {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+    key := refKey(ref)
+    if id, ok := r.ids[key]; ok {
+        return id
+    }
+    r.nextID++
+    id := runtimeAgent.RemoteObjectId(fmt.Sprintf("%s%d", objectIdPrefix, r.nextID))
+    r.objects[id] = ref
+    r.ids[key] = id
+    return id
+}
+
+func (r *objectRegistry) Get(id runtimeAgent.RemoteObjectId) (objectRef, bool) {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+    ref, ok := r.objects[id]
+    return ref, ok
+}
+
+func (r *objectRegistry) Release(id runtimeAgent.RemoteObjectId) {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+    if ref, ok := r.objects[id]; ok {
+        delete(r.ids, refKey(ref))
+        delete(r.objects, id)
+    }
+}
+
+func (r *objectRegistry) ReleaseGroup(group string) {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+    for id, ref := range r.objects {
+        if ref.Group == group {
+            delete(r.ids, refKey(ref))
+            delete(r.objects, id)
+        }
+    }
+}
+
+func (r *objectRegistry) ReleaseAll() {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+    r.objects = map[runtimeAgent.RemoteObjectId]objectRef{}
+    r.ids = map[string]runtimeAgent.RemoteObjectId{}
+}
+
+func children(variable dbgClient.Variable) []dbgClient.Variable {
+    return dbgClient.Variables(variable.Children)
+}
+
+// Values the frontend can expand.
+func isComposite(variable dbgClient.Variable) bool {
+    switch variable.Kind {
+    case reflect.Struct, reflect.Array, reflect.Slice, reflect.Map:
+        return true
+    case reflect.Chan:
+        return !isNil(variable)
+    case reflect.Func:
+        // Expanding a function shows where it is and what it captured.
+        return variable.Value != ""
+    case reflect.String:
+        return isTruncatedString(variable)
+    case reflect.Ptr, reflect.Interface:
+        return len(variable.Children) > 0 && variable.Children[0].Kind != reflect.Invalid
+    }
+    return false
+}
+
+// Wraps expressions that would bind wrong when something is appended to them.
+func parenthesize(expression string) string {
+    if strings.HasPrefix(expression, "*") {
+        return "(" + expression + ")"
+    }
+    return expression
+}
+
+// Names in a type that may be qualified by an import path, like net/http.Request in map[string]*net/http.Request.
+var qualifiedNamePattern = regexp.MustCompile(`[\w.~/-]+`)
+
+// A type as delve prints it, written so delve can parse it back. Import paths with a slash read as a division, so
+// they are quoted like "net/http".Request. The last dot separates the type name, paths like gopkg.in/yaml.v2 have more.
+func typeExpression(typeName string) string {
+    return qualifiedNamePattern.ReplaceAllStringFunc(typeName, func(name string) string {
+        dot := strings.LastIndex(name, ".")
+        if !strings.Contains(name, "/") || dot < strings.LastIndex(name, "/") {
+            return name
+        }
+        return strconv.Quote(name[:dot]) + name[dot:]
+    })
+}
+
+// Expression that gets the value from its address, for when there is no path to it.
+func addressExpression(variable dbgClient.Variable) string {
+    return fmt.Sprintf("*(*%s)(%#x)", typeExpression(variable.Type), variable.Addr)
+}
+
+// Go syntax for a map key, if the key can be written as a constant delve understands.
+func mapKeyExpression(key dbgClient.Variable) (string, bool) {
+    switch key.Kind {
+    case reflect.String:
+        return strconv.Quote(key.Value), true
+    case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint,
+         reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        return key.Value, true
+    }
+    return "", false
+}
+
+func childRef(parent objectRef, expression string) *objectRef {
+    return &objectRef{
+        Scope: parent.Scope,
+        Expression: expression,
+        Group: parent.Group,
+    }
+}
+
+func (p *proxy) makeProperty(name string, variable dbgClient.Variable, ref *objectRef) runtimeAgent.PropertyDescriptor {
+    remoteObject := p.makeRemoteObject(variable, ref)
+    return runtimeAgent.PropertyDescriptor{
+        Name: name,
+        Value: &remoteObject,
+        Enumerable: true,
+    }
+}
+
+// Lists the entries of a loaded map, ref.Expression being the map itself.
+func (p *proxy) mapEntryProperties(variable dbgClient.Variable, ref objectRef) []runtimeAgent.PropertyDescriptor {
+    properties := []runtimeAgent.PropertyDescriptor{}
+    expression := parenthesize(ref.Expression)
+    // Delve gives us keys and values one after the other.
+    entries := children(variable)
+    for i := 0; i + 1 < len(entries); i += 2 {
+        key := entries[i]
+        value := entries[i + 1]
+        valueExpression := addressExpression(value)
+        keyExpression, ok := mapKeyExpression(key)
+        if ok {
+            valueExpression = fmt.Sprintf("%s[%s]", expression, keyExpression)
+        }
+        name := key.Value
+        if !ok {
+            // Keys like structs and pointers have no name of their own. The position keeps two that describe
+            // the same from ending up as one property.
+            name = fmt.Sprintf("[%d] %s", ref.RangeStart + int64(i / 2), p.describeVariable(key))
+        }
+        properties = append(properties, p.makeProperty(name, value, childRef(ref, valueExpression)))
+    }
+    return properties
+}
+
+// Lists the properties of a value loaded from ref.Expression.
+func (p *proxy) expandVariable(variable dbgClient.Variable, ref objectRef) []runtimeAgent.PropertyDescriptor {
+    properties := []runtimeAgent.PropertyDescriptor{}
+    expression := parenthesize(ref.Expression)
+    switch variable.Kind {
+    case reflect.Struct:
+        for _, field := range children(variable) {
+            properties = append(properties, p.makeProperty(field.Name, field, childRef(ref, expression + "." + field.Name)))
+        }
+    case reflect.String:
+        if isTruncatedString(variable) {
+            return p.bucketProperties(ref, reflect.String, 0, variable.Len)
+        }
+    case reflect.Chan:
+        return p.expandChan(variable, ref)
+    case reflect.Func:
+        return p.expandFunction(variable, ref)
+    case reflect.Array, reflect.Slice:
+        if int64(len(variable.Children)) < variable.Len {
+            return p.bucketProperties(ref, variable.Kind, 0, variable.Len)
+        }
+        for index, element := range children(variable) {
+            properties = append(properties, p.makeProperty(strconv.Itoa(index), element, childRef(ref, fmt.Sprintf("%s[%d]", expression, index))))
+        }
+    case reflect.Map:
+        if int64(len(variable.Children) / 2) < variable.Len {
+            return p.bucketProperties(ref, reflect.Map, 0, variable.Len)
+        }
+        return p.mapEntryProperties(variable, ref)
+    case reflect.Ptr:
+        if len(variable.Children) == 0 {
+            break
+        }
+        target := children(variable)[0]
+        targetRef := childRef(ref, "*" + expression)
+        if isComposite(target) {
+            // Pointers to things are shown as the thing itself, like devtools does for js objects.
+            return p.expandVariable(target, *targetRef)
+        }
+        properties = append(properties, p.makeProperty("*", target, targetRef))
+    case reflect.Interface:
+        if len(variable.Children) == 0 {
+            break
+        }
+        data := children(variable)[0]
+        dataRef := childRef(ref, fmt.Sprintf("%s.(%s)", expression, typeExpression(data.Type)))
+        if isComposite(data) {
+            return p.expandVariable(data, *dataRef)
+        }
+        properties = append(properties, p.makeProperty("data", data, dataRef))
+    }
+    return properties
+}
+
+func (p *proxy) getObjectPropertiesAndRespond(command runtimeAgent.GetPropertiesCommand) {
+    ref, ok := p.objects.Get(command.ObjectId)
+    if !ok {
+        command.RespondWithError(shared.ErrorCodeInvalidParams, "Could not find object with given id")
+        return
+    }
+    if ref.IsRange {
+        p.getRangePropertiesAndRespond(command, ref)
+        return
+    }
+    variable, err := p.client.EvalVariable(ref.Scope, ref.Expression, p.LoadConfig())
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    internal := p.internalProperties(*variable, command.DestinationTargetID)
+    command.Respond(&runtimeAgent.GetPropertiesReturn{
+        Result: p.expandVariable(*variable, ref),
+        InternalProperties: &internal,
+    })
+}
+
+func (p *proxy) releaseObjectAndRespond(command runtimeAgent.ReleaseObjectCommand) {
+    p.objects.Release(command.ObjectId)
+    command.Respond()
+}
+
+func (p *proxy) releaseObjectGroupAndRespond(command runtimeAgent.ReleaseObjectGroupCommand) {
+    p.objects.ReleaseGroup(command.ObjectGroup)
+    command.Respond()
+}
+
+// Object ids point at values in a stopped program. Once it runs again they mean nothing.
+func (p *proxy) ReleaseAllObjects() {
+    p.objects.ReleaseAll()
+    p.forgetReloadedErrors()
+    // The same goes for stored values that were on a stack.
+    p.temps.ForgetStack()
+}
@@ -0,0 +1,152 @@
+package runtime
+
+import (
+    "fmt"
+    "reflect"
+    "strconv"
+    "github.com/allada/gdd/config"
+    "github.com/allada/gdd/dbgClient"
+    "github.com/allada/gdd/protocol/shared"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+func (p *proxy) SetLoadLimits(limits config.LoadLimits) {
+    p.loadLimitsMux.Lock()
+    defer p.loadLimitsMux.Unlock()
+    p.loadLimits = limits
+}
+
+func (p *proxy) LoadLimits() config.LoadLimits {
+    p.loadLimitsMux.RLock()
+    defer p.loadLimitsMux.RUnlock()
+    return p.loadLimits
+}
+
+// The LoadConfig every value of this session is loaded with.
+func (p *proxy) LoadConfig() dbgClient.LoadConfig {
+    limits := p.LoadLimits()
+    return dbgClient.LoadConfig{
+        FollowPointers: true,
+        MaxVariableRecurse: 1,
+        MaxStringLen: limits.MaxStringLen,
+        MaxArrayValues: limits.MaxArrayValues,
+        MaxStructFields: -1,
+    }
+}
+
+func isTruncatedString(variable dbgClient.Variable) bool {
+    return variable.Kind == reflect.String && int64(len(variable.Value)) < variable.Len
+}
+
+// Number of elements, or bytes for strings, that are listed directly instead of being split in groups.
+func (p *proxy) leafRangeSize(kind reflect.Kind) int64 {
+    limits := p.LoadLimits()
+    if kind == reflect.String {
+        return int64(limits.MaxStringLen) * int64(limits.MaxArrayValues)
+    }
+    return int64(limits.MaxArrayValues)
+}
+
+// Same as devtools does for big arrays.
+func rangeName(start int64, end int64) string {
+    return fmt.Sprintf("[%d … %d]", start, end - 1)
+}
+
+// Splits [start, end) in at most MaxArrayValues groups, each a range object the frontend can expand.
+func (p *proxy) bucketProperties(ref objectRef, kind reflect.Kind, start int64, end int64) []runtimeAgent.PropertyDescriptor {
+    maxBuckets := int64(p.LoadLimits().MaxArrayValues)
+    size := p.leafRangeSize(kind)
+    for (end - start + size - 1) / size > maxBuckets {
+        size *= maxBuckets
+    }
+    properties := []runtimeAgent.PropertyDescriptor{}
+    for bucketStart := start; bucketStart < end; bucketStart += size {
+        bucketEnd := bucketStart + size
+        if bucketEnd > end {
+            bucketEnd = end
+        }
+        id := p.objects.Register(objectRef{
+            Scope: ref.Scope,
+            Expression: ref.Expression,
+            Group: ref.Group,
+            IsRange: true,
+            RangeKind: kind,
+            RangeStart: bucketStart,
+            RangeEnd: bucketEnd,
+        })
+        description := rangeName(bucketStart, bucketEnd)
+        subtype := runtimeAgent.RemoteObjectSubtypeArray
+        properties = append(properties, runtimeAgent.PropertyDescriptor{
+            Name: description,
+            Value: &runtimeAgent.RemoteObject{
+                Type: runtimeAgent.RemoteObjectTypeObject,
+                Subtype: &subtype,
+                Description: &description,
+                ObjectId: &id,
+            },
+            Enumerable: true,
+        })
+    }
+    return properties
+}
+
+// Loads and lists a range of a collection or string that is small enough to show directly.
+func (p *proxy) expandRange(ref objectRef) ([]runtimeAgent.PropertyDescriptor, error) {
+    if ref.RangeEnd - ref.RangeStart > p.leafRangeSize(ref.RangeKind) {
+        return p.bucketProperties(ref, ref.RangeKind, ref.RangeStart, ref.RangeEnd), nil
+    }
+    cfg := p.LoadConfig()
+    if ref.RangeKind == reflect.String {
+        cfg.MaxStringLen = int(ref.RangeEnd - ref.RangeStart)
+    } else {
+        cfg.MaxArrayValues = int(ref.RangeEnd - ref.RangeStart)
+    }
+    // Delve reslices from the start offset and stops at the load limits.
+    expression := fmt.Sprintf("%s[%d:]", parenthesize(ref.Expression), ref.RangeStart)
+    variable, err := p.client.EvalVariable(ref.Scope, expression, cfg)
+    if err != nil {
+        return nil, err
+    }
+
+    properties := []runtimeAgent.PropertyDescriptor{}
+    switch ref.RangeKind {
+    case reflect.String:
+        // Long strings are shown as chunks of MaxStringLen bytes.
+        chunkSize := p.LoadLimits().MaxStringLen
+        value := variable.Value
+        for offset := 0; offset < len(value); offset += chunkSize {
+            end := offset + chunkSize
+            if end > len(value) {
+                end = len(value)
+            }
+            properties = append(properties, runtimeAgent.PropertyDescriptor{
+                Name: rangeName(ref.RangeStart + int64(offset), ref.RangeStart + int64(end)),
+                Value: &runtimeAgent.RemoteObject{
+                    Type: runtimeAgent.RemoteObjectTypeString,
+                    Value: value[offset:end],
+                },
+                Enumerable: true,
+            })
+        }
+    case reflect.Map:
+        properties = p.mapEntryProperties(*variable, ref)
+    default:
+        expression := parenthesize(ref.Expression)
+        for index, element := range children(*variable) {
+            elementIndex := ref.RangeStart + int64(index)
+            properties = append(properties, p.makeProperty(strconv.FormatInt(elementIndex, 10), element, childRef(ref, fmt.Sprintf("%s[%d]", expression, elementIndex))))
+        }
+    }
+    return properties, nil
+}
+
+func (p *proxy) getRangePropertiesAndRespond(command runtimeAgent.GetPropertiesCommand, ref objectRef) {
+    properties, err := p.expandRange(ref)
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    command.Respond(&runtimeAgent.GetPropertiesReturn{
+        Result: properties,
+    })
+}
@@ -0,0 +1,234 @@
+package runtime
+
+import (
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "github.com/allada/gdd/protocol/shared"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// The runtime writes a traceback in one go, so a pause this long means it is done.
+const tracebackFlushDelay = 200 * time.Millisecond
+
+// Lines a panic message may have before the first goroutine, past that it was not a traceback after all.
+const maxTracebackMessageLines = 100
+
+var goroutineHeaderPattern = regexp.MustCompile(`^goroutine (\d+)(?: gp=\S+ m=\S+(?: mp=\S+)?)? \[(.*)\]:$`)
+var frameFilePattern = regexp.MustCompile(`^\t(.+\.\w+):(\d+)(?: \+0x[0-9a-f]+)?$`)
+var createdByPattern = regexp.MustCompile(`^created by (\S+)(?: in goroutine \d+)?$`)
+
+func startsTraceback(line string) bool {
+    return strings.HasPrefix(line, "panic: ") || strings.HasPrefix(line, "fatal error: ")
+}
+
+// Stack of one goroutine as the runtime prints it.
+type goroutineTrace struct {
+    Header string // "goroutine 1 [running]"
+    Frames []runtimeAgent.CallFrame
+}
+
+// A panic or fatal error read from stderr.
+type traceback struct {
+    Message []string
+    Goroutines []*goroutineTrace
+}
+
+// Collects the lines of a traceback while it is being written.
+type tracebackCollector struct {
+    mux sync.Mutex
+    lines []string
+    timer *time.Timer
+    exceptionCount int64
+}
+
+// Name of the function a frame line like main.(*T).Run(0xc000010000, {0x1, 0x2}) is for.
+func frameFunctionName(line string) (string, bool) {
+    if !strings.HasSuffix(line, ")") || strings.HasPrefix(line, "\t") {
+        return "", false
+    }
+    depth := 0
+    for i := len(line) - 1; i >= 0; i-- {
+        switch line[i] {
+        case ')':
+            depth++
+        case '(':
+            depth--
+            if depth == 0 {
+                name := line[:i]
+                if name == "" || strings.ContainsAny(name, " \t") {
+                    return "", false
+                }
+                return name, true
+            }
+        }
+    }
+    return "", false
+}
+
+// Whether line can be part of a traceback once the goroutines started.
+func isTracebackLine(line string) bool {
+    if line == "" || startsTraceback(line) || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "[signal ") ||
+       line == "runtime stack:" || line == "...additional frames elided..." {
+        return true
+    }
+    if goroutineHeaderPattern.MatchString(line) || createdByPattern.MatchString(line) {
+        return true
+    }
+    _, ok := frameFunctionName(line)
+    return ok
+}
+
+func parseTraceback(lines []string) traceback {
+    result := traceback{}
+    var current *goroutineTrace
+    pendingFunction := ""
+    for _, line := range lines {
+        if match := goroutineHeaderPattern.FindStringSubmatch(line); match != nil {
+            current = &goroutineTrace{Header: "goroutine " + match[1] + " [" + match[2] + "]"}
+            result.Goroutines = append(result.Goroutines, current)
+            pendingFunction = ""
+            continue
+        }
+        if current == nil {
+            if line != "" {
+                result.Message = append(result.Message, strings.TrimPrefix(line, "\t"))
+            }
+            continue
+        }
+        if match := frameFilePattern.FindStringSubmatch(line); match != nil && pendingFunction != "" {
+            lineNumber, _ := strconv.ParseInt(match[2], 10, 64)
+            current.Frames = append(current.Frames, runtimeAgent.CallFrame{
+                FunctionName: pendingFunction,
+                ScriptId: runtimeAgent.ScriptId(match[1]),
+                Url: match[1],
+                LineNumber: lineNumber - 1,
+            })
+            pendingFunction = ""
+            continue
+        }
+        if match := createdByPattern.FindStringSubmatch(line); match != nil {
+            pendingFunction = "created by " + match[1]
+            continue
+        }
+        if name, ok := frameFunctionName(line); ok {
+            pendingFunction = name
+            continue
+        }
+        pendingFunction = ""
+    }
+    return result
+}
+
+// Where the panic happened as far as the user is concerned, the first frame that is not inside the runtime.
+func (t traceback) userFrame() (runtimeAgent.CallFrame, bool) {
+    if len(t.Goroutines) == 0 {
+        return runtimeAgent.CallFrame{}, false
+    }
+    for _, frame := range t.Goroutines[0].Frames {
+        if !strings.HasPrefix(frame.FunctionName, "runtime.") && frame.FunctionName != "panic" {
+            return frame, true
+        }
+    }
+    return runtimeAgent.CallFrame{}, false
+}
+
+// The goroutine that panicked comes first in the dump. The others hang off it as parents, each labelled with its
+// goroutine so devtools shows them one after another.
+func (t traceback) stackTrace() *runtimeAgent.StackTrace {
+    var stackTrace *runtimeAgent.StackTrace
+    for i := len(t.Goroutines) - 1; i >= 0; i-- {
+        header := t.Goroutines[i].Header
+        stackTrace = &runtimeAgent.StackTrace{
+            Description: &header,
+            CallFrames: t.Goroutines[i].Frames,
+            Parent: stackTrace,
+        }
+    }
+    return stackTrace
+}
+
+func (p *proxy) handleStderrLine(line string) {
+    c := p.tracebacks
+    c.mux.Lock()
+    defer c.mux.Unlock()
+    if c.lines != nil {
+        if p.continuesTraceback(c.lines, line) {
+            c.lines = append(c.lines, line)
+            c.timer.Reset(tracebackFlushDelay)
+            return
+        }
+        p.flushTracebackLocked()
+    }
+    if startsTraceback(line) {
+        c.lines = []string{line}
+        c.timer = time.AfterFunc(tracebackFlushDelay, shared.WrapFunctionForPanicRecover(p.flushTraceback, p.conn))
+        return
+    }
+    p.sendConsoleLine(runtimeAgent.ConsoleAPICalledTypeError, line, p.callsite(2, line))
+}
+
+func (p *proxy) continuesTraceback(lines []string, line string) bool {
+    for _, previous := range lines {
+        if goroutineHeaderPattern.MatchString(previous) {
+            return isTracebackLine(line)
+        }
+    }
+    // Still in the message, which can be anything the program passed to panic.
+    return len(lines) < maxTracebackMessageLines
+}
+
+func (p *proxy) flushTraceback() {
+    p.tracebacks.mux.Lock()
+    defer p.tracebacks.mux.Unlock()
+    p.flushTracebackLocked()
+}
+
+// Sends the collected lines as an exception, or as plain lines if they were not a traceback.
+func (p *proxy) flushTracebackLocked() {
+    c := p.tracebacks
+    lines := c.lines
+    c.lines = nil
+    if c.timer != nil {
+        c.timer.Stop()
+    }
+    if lines == nil {
+        return
+    }
+    parsed := parseTraceback(lines)
+    if len(parsed.Goroutines) == 0 {
+        for _, line := range lines {
+            p.sendConsoleLine(runtimeAgent.ConsoleAPICalledTypeError, line, p.callsite(2, line))
+        }
+        return
+    }
+    text := strings.Join(parsed.Message, "\n")
+    subtype := runtimeAgent.RemoteObjectSubtypeError
+    contextId := executionContextId
+    c.exceptionCount++
+    details := runtimeAgent.ExceptionDetails{
+        ExceptionId: c.exceptionCount,
+        Text: text,
+        LineNumber: -1,
+        ColumnNumber: -1,
+        StackTrace: parsed.stackTrace(),
+        Exception: &runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeObject,
+            Subtype: &subtype,
+            Description: &text,
+        },
+        ExecutionContextId: &contextId,
+    }
+    if frame, ok := parsed.userFrame(); ok {
+        details.LineNumber = frame.LineNumber
+        details.ColumnNumber = 0
+        details.ScriptId = &frame.ScriptId
+        details.Url = &frame.Url
+    }
+    p.agent.FireExceptionThrown(runtimeAgent.ExceptionThrownEvent{
+        Timestamp: now(),
+        ExceptionDetails: details,
+    })
+}
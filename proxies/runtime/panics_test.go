@@ -0,0 +1,118 @@
+package runtime
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+func TestFrameFunctionName(t *testing.T) {
+    tests := []struct {
+        line string
+        name string
+        ok bool
+    }{
+        {"main.main()", "main.main", true},
+        {"main.(*T).Run(0xc000010000, {0x1, 0x2})", "main.(*T).Run", true},
+        {"net/http.(*conn).serve(0xc0000b4000, {0x6e8f40, 0xc0000a2000})", "net/http.(*conn).serve", true},
+        {"main.main.func1(...)", "main.main.func1", true},
+        {"\t/src/main.go:10 +0x1d", "", false},
+        {"exit status 2", "", false},
+        {"panic(0x4a1b20)", "panic", true},
+        {"(0x1)", "", false},
+        {"some text (with parens)", "", false},
+    }
+    for _, test := range tests {
+        name, ok := frameFunctionName(test.line)
+        if name != test.name || ok != test.ok {
+            t.Errorf("frameFunctionName(%q) = %q, %v, want %q, %v", test.line, name, ok, test.name, test.ok)
+        }
+    }
+}
+
+func TestIsTracebackLine(t *testing.T) {
+    tests := []struct {
+        line string
+        want bool
+    }{
+        {"", true},
+        {"goroutine 1 [running]:", true},
+        {"goroutine 18 gp=0xc000102380 m=nil [chan receive]:", true},
+        {"\t/src/main.go:10 +0x1d", true},
+        {"created by main.main in goroutine 1", true},
+        {"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4553c6]", true},
+        {"main.main()", true},
+        {"...additional frames elided...", true},
+        {"listening on :8080", false},
+        {"exit status 2", false},
+    }
+    for _, test := range tests {
+        if got := isTracebackLine(test.line); got != test.want {
+            t.Errorf("isTracebackLine(%q) = %v, want %v", test.line, got, test.want)
+        }
+    }
+}
+
+const testTraceback = `panic: something broke
+	[recovered]
+
+goroutine 1 [running]:
+runtime.gopanic({0x4a1b20, 0xc000014270})
+	/usr/local/go/src/runtime/panic.go:770 +0x132
+main.(*server).handle(0xc000010000)
+	/src/app/main.go:42 +0x45
+main.main()
+	/src/app/main.go:10 +0x1d
+
+goroutine 7 [chan receive]:
+main.worker(0xc000020060)
+	/src/app/worker.go:8 +0x2b
+created by main.main in goroutine 1
+	/src/app/main.go:9 +0x18
+`
+
+func TestParseTraceback(t *testing.T) {
+    result := parseTraceback(strings.Split(testTraceback, "\n"))
+    if want := []string{"panic: something broke", "[recovered]"}; !reflect.DeepEqual(result.Message, want) {
+        t.Errorf("Message = %q, want %q", result.Message, want)
+    }
+    frame := func(name string, file string, line int64) runtimeAgent.CallFrame {
+        return runtimeAgent.CallFrame{
+            FunctionName: name,
+            ScriptId: runtimeAgent.ScriptId(file),
+            Url: file,
+            LineNumber: line,
+        }
+    }
+    want := []*goroutineTrace{
+        {
+            Header: "goroutine 1 [running]",
+            Frames: []runtimeAgent.CallFrame{
+                frame("runtime.gopanic", "/usr/local/go/src/runtime/panic.go", 769),
+                frame("main.(*server).handle", "/src/app/main.go", 41),
+                frame("main.main", "/src/app/main.go", 9),
+            },
+        },
+        {
+            Header: "goroutine 7 [chan receive]",
+            Frames: []runtimeAgent.CallFrame{
+                frame("main.worker", "/src/app/worker.go", 7),
+                frame("created by main.main", "/src/app/main.go", 8),
+            },
+        },
+    }
+    if !reflect.DeepEqual(result.Goroutines, want) {
+        t.Errorf("Goroutines = %+v, want %+v", result.Goroutines, want)
+    }
+
+    userFrame, ok := result.userFrame()
+    if !ok || userFrame.FunctionName != "main.(*server).handle" {
+        t.Errorf("userFrame() = %q, %v, want main.(*server).handle", userFrame.FunctionName, ok)
+    }
+    stackTrace := result.stackTrace()
+    if stackTrace == nil || stackTrace.Parent == nil || *stackTrace.Parent.Description != "goroutine 7 [chan receive]" ||
+       stackTrace.Parent.Parent != nil {
+        t.Errorf("stackTrace() does not chain the goroutines in order")
+    }
+}
@@ -0,0 +1,266 @@
+package runtime
+
+import (
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+    "github.com/allada/gdd/dbgClient"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// How many fields, elements or entries are shown inline for a collapsed value. Devtools shows 5 for js objects too.
+const previewMaxProperties = 5
+
+// Longest a value may get inside a description before it is cut off.
+const previewMaxValueLen = 50
+
+func truncate(text string, maxLen int) string {
+    if len(text) <= maxLen {
+        return text
+    }
+    return text[:maxLen] + "…"
+}
+
+// Devtools type (and subtype) a go value is shown as.
+func remoteObjectType(variable dbgClient.Variable) (runtimeAgent.RemoteObjectTypeEnum, *runtimeAgent.RemoteObjectSubtypeEnum) {
+    var subtype runtimeAgent.RemoteObjectSubtypeEnum
+    switch variable.Kind {
+    case reflect.Invalid:
+        // Calls to functions without results.
+        return runtimeAgent.RemoteObjectTypeUndefined, nil
+    case reflect.Bool:
+        return runtimeAgent.RemoteObjectTypeBoolean, nil
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8,
+         reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64,
+         reflect.Complex64, reflect.Complex128:
+        return runtimeAgent.RemoteObjectTypeNumber, nil
+    case reflect.String:
+        if isTruncatedString(variable) {
+            return runtimeAgent.RemoteObjectTypeObject, nil
+        }
+        return runtimeAgent.RemoteObjectTypeString, nil
+    case reflect.Func:
+        return runtimeAgent.RemoteObjectTypeFunction, nil
+    case reflect.Array, reflect.Slice:
+        subtype = runtimeAgent.RemoteObjectSubtypeArray
+        return runtimeAgent.RemoteObjectTypeObject, &subtype
+    case reflect.Map:
+        subtype = runtimeAgent.RemoteObjectSubtypeMap
+        return runtimeAgent.RemoteObjectTypeObject, &subtype
+    case reflect.Struct:
+        return runtimeAgent.RemoteObjectTypeObject, nil
+    case reflect.Ptr, reflect.Interface, reflect.Chan, reflect.UnsafePointer:
+        if isComposite(variable) {
+            return runtimeAgent.RemoteObjectTypeObject, nil
+        }
+        if isNil(variable) {
+            subtype = runtimeAgent.RemoteObjectSubtypeNull
+            return runtimeAgent.RemoteObjectTypeObject, &subtype
+        }
+        // Pointers to values we cannot expand, like *int, are still shown as addresses.
+        return runtimeAgent.RemoteObjectTypeObject, nil
+    }
+    return runtimeAgent.RemoteObjectTypeSymbol, nil
+}
+
+func isNil(variable dbgClient.Variable) bool {
+    switch variable.Kind {
+    case reflect.Ptr, reflect.UnsafePointer:
+        return pointerValue(variable) == 0
+    case reflect.Chan:
+        return variable.Base == 0 && len(variable.Children) == 0
+    case reflect.Interface:
+        return len(variable.Children) == 0 || variable.Children[0].Kind == reflect.Invalid
+    case reflect.Slice, reflect.Map:
+        return variable.Base == 0
+    }
+    return false
+}
+
+// Go flavoured one line description of a value, like "[]int len:3 cap:8" or "*http.Request 0xc000123".
+func (p *proxy) describeVariable(variable dbgClient.Variable) string {
+    if variable.Unreadable != "" {
+        return "(unreadable " + variable.Unreadable + ")"
+    }
+    if description, ok := p.format(variable); ok {
+        return description
+    }
+    switch variable.Kind {
+    case reflect.String:
+        if isTruncatedString(variable) {
+            return strconv.Quote(truncate(variable.Value, previewMaxValueLen)) + "…"
+        }
+        return strconv.Quote(variable.Value)
+    case reflect.Struct:
+        fields := []string{}
+        for i, field := range children(variable) {
+            if i == previewMaxProperties - 1 && len(variable.Children) > previewMaxProperties {
+                fields = append(fields, "…")
+                break
+            }
+            fields = append(fields, field.Name + ": " + p.shortDescribeVariable(field))
+        }
+        return variable.Type + "{" + strings.Join(fields, ", ") + "}"
+    case reflect.Array:
+        return variable.Type
+    case reflect.Slice, reflect.Map:
+        if isNil(variable) {
+            return variable.Type + " nil"
+        }
+        if variable.Kind == reflect.Map {
+            return fmt.Sprintf("%s len:%d", variable.Type, variable.Len)
+        }
+        return fmt.Sprintf("%s len:%d cap:%d", variable.Type, variable.Len, variable.Cap)
+    case reflect.Ptr:
+        if isNil(variable) {
+            return variable.Type + " nil"
+        }
+        if description, ok := p.format(children(variable)[0]); ok {
+            return "&" + description
+        }
+        return fmt.Sprintf("%s %#x", variable.Type, variable.Children[0].Addr)
+    case reflect.Interface:
+        if isNil(variable) {
+            return variable.Type + " nil"
+        }
+        // Same as delve prints them: static type, dynamic type, value.
+        data := children(variable)[0]
+        return variable.Type + "(" + data.Type + ") " + p.describeVariable(data)
+    case reflect.Chan:
+        return describeChan(variable)
+    case reflect.Func:
+        if variable.Value == "" {
+            return variable.Type + " nil"
+        }
+        return "func " + variable.Value
+    case reflect.Complex64, reflect.Complex128:
+        return complexValue(variable)
+    case reflect.UnsafePointer:
+        if isNil(variable) {
+            return "unsafe.Pointer(nil)"
+        }
+        return fmt.Sprintf("unsafe.Pointer(%#x)", pointerValue(variable))
+    }
+    if variable.Value == "" {
+        return variable.Type
+    }
+    return variable.Value
+}
+
+// Like describeVariable, but short enough to be shown as part of another value.
+func (p *proxy) shortDescribeVariable(variable dbgClient.Variable) string {
+    if description, ok := p.format(variable); ok {
+        return truncate(description, previewMaxValueLen)
+    }
+    switch variable.Kind {
+    case reflect.Struct:
+        return variable.Type + "{…}"
+    case reflect.Interface:
+        if !isNil(variable) {
+            return p.shortDescribeVariable(children(variable)[0])
+        }
+    }
+    return truncate(p.describeVariable(variable), previewMaxValueLen)
+}
+
+// Preview of a value that is not an object, used for map keys and values.
+func (p *proxy) valuePreview(variable dbgClient.Variable) runtimeAgent.ObjectPreview {
+    outKind, _ := remoteObjectType(variable)
+    description := p.shortDescribeVariable(variable)
+    return runtimeAgent.ObjectPreview{
+        Type: runtimeAgent.ObjectPreviewTypeEnum(outKind),
+        Description: &description,
+        Properties: []runtimeAgent.PropertyPreview{},
+    }
+}
+
+func (p *proxy) propertyPreview(name string, variable dbgClient.Variable) runtimeAgent.PropertyPreview {
+    outKind, subtype := remoteObjectType(variable)
+    value := p.shortDescribeVariable(variable)
+    if variable.Kind == reflect.String && !isTruncatedString(variable) {
+        // Devtools adds the quotes itself.
+        value = truncate(variable.Value, previewMaxValueLen)
+    }
+    var previewSubtype *runtimeAgent.PropertyPreviewSubtypeEnum
+    if subtype != nil {
+        s := runtimeAgent.PropertyPreviewSubtypeEnum(*subtype)
+        previewSubtype = &s
+    }
+    return runtimeAgent.PropertyPreview{
+        Name: name,
+        Type: runtimeAgent.PropertyPreviewTypeEnum(outKind),
+        Value: &value,
+        Subtype: previewSubtype,
+    }
+}
+
+// Fills the inline preview devtools shows next to collapsed values from the first few fields, elements or entries.
+func (p *proxy) makePreview(variable dbgClient.Variable) *runtimeAgent.ObjectPreview {
+    outKind, subtype := remoteObjectType(variable)
+    if outKind != runtimeAgent.RemoteObjectTypeObject {
+        return nil
+    }
+    if _, ok := p.format(variable); ok {
+        // The raw fields would only distract from the description.
+        return nil
+    }
+    description := p.describeVariable(variable)
+    preview := &runtimeAgent.ObjectPreview{
+        Type: runtimeAgent.ObjectPreviewTypeObject,
+        Description: &description,
+        Properties: []runtimeAgent.PropertyPreview{},
+    }
+    if subtype != nil {
+        previewSubtype := runtimeAgent.ObjectPreviewSubtypeEnum(*subtype)
+        preview.Subtype = &previewSubtype
+    }
+
+    switch variable.Kind {
+    case reflect.Struct:
+        for i, field := range children(variable) {
+            if i == previewMaxProperties {
+                preview.Overflow = true
+                break
+            }
+            preview.Properties = append(preview.Properties, p.propertyPreview(field.Name, field))
+        }
+    case reflect.Array, reflect.Slice:
+        for i, element := range children(variable) {
+            if i == previewMaxProperties {
+                break
+            }
+            preview.Properties = append(preview.Properties, p.propertyPreview(strconv.Itoa(i), element))
+        }
+        preview.Overflow = variable.Len > previewMaxProperties
+    case reflect.Chan:
+        if !isNil(variable) {
+            info := loadChanInfo(variable)
+            preview.Properties = append(preview.Properties,
+                p.propertyPreview("len", dbgClient.Variable{Kind: reflect.Int, Value: strconv.FormatInt(info.Len, 10)}),
+                p.propertyPreview("cap", dbgClient.Variable{Kind: reflect.Int, Value: strconv.FormatInt(info.Cap, 10)}))
+        }
+    case reflect.Map:
+        entries := []runtimeAgent.EntryPreview{}
+        pairs := children(variable)
+        for i := 0; i + 1 < len(pairs) && len(entries) < previewMaxProperties; i += 2 {
+            key := p.valuePreview(pairs[i])
+            entries = append(entries, runtimeAgent.EntryPreview{
+                Key: &key,
+                Value: p.valuePreview(pairs[i + 1]),
+            })
+        }
+        preview.Entries = &entries
+        preview.Overflow = variable.Len > int64(len(entries))
+    case reflect.Ptr, reflect.Interface:
+        if isComposite(variable) {
+            // Shown as the value they point at, but keep our own description.
+            target := p.makePreview(children(variable)[0])
+            if target != nil {
+                target.Description = &description
+                return target
+            }
+        }
+    }
+    return preview
+}
@@ -3,43 +3,190 @@ package runtime
 import (
     "fmt"
     "bufio"
+    "context"
+    "io"
+    "os/exec"
     "strconv"
     "strings"
     "reflect"
+    "sync"
     "sync/atomic"
+    "syscall"
+    "text/template"
+    "time"
+    "github.com/allada/gdd/config"
     "github.com/allada/gdd/dbgClient"
     "github.com/allada/gdd/protocol/shared"
+    logAgent "github.com/allada/gdd/protocol/log"
     runtimeAgent "github.com/allada/gdd/protocol/runtime"
 )
 
+// Id of the one execution context we create. Everything the program does happens in it.
+const executionContextId runtimeAgent.ExecutionContextId = 1
+
 type proxy struct {
     agent *runtimeAgent.RuntimeAgent
+    logAgent *logAgent.LogAgent
     client *dbgClient.Client
     conn *shared.Connection
 
     enabled int32 // Since Go does not have atomic_flag I use int32
+    verbose int32 // Since Go does not have atomic_flag I use int32
+    running int32 // Since Go does not have atomic_flag I use int32
+    contextCreated int32 // Since Go does not have atomic_flag I use int32
+    callsites int32 // Since Go does not have atomic_flag I use int32
+    callsiteWrites *callsiteQueue
+    stdinMux sync.Mutex
+    logEnabled int32 // Since Go does not have atomic_flag I use int32
+    logObjects *logObjectStore
+    tracebacks *tracebackCollector
+    objects *objectRegistry
+    temps *tempStore
+    functionLocationsMux sync.Mutex
+    functionLocations map[string]dbgClient.Location // Function name -> where it starts, empty if unknown.
+    typeInfosMux sync.Mutex
+    typeInfos map[string]typeInfo // Type name -> what we found out about it.
+    packageSources map[string][]string // Import path -> its source files, nil until a type needs it.
+    packageNames map[string]string // Import path -> name in its package clause.
+    objectIdHandlersMux sync.RWMutex
+    objectIdHandlers map[string]func(runtimeAgent.GetPropertiesCommand)
+    evalScopeHandlerMux sync.RWMutex
+    evalScopeHandler func(targetID string) (dbgClient.EvalScope, error)
+    frameSelectedHandler func(goroutineID int, frame int)
+    callReturnedHandler func(goroutineID int)
+    scriptHandler func(targetID string, file string)
+    debuggerSettings DebuggerSettings
+    formattersMux sync.RWMutex
+    formattersEnabled bool
+    userFormatters map[string]*template.Template // Type name -> formatter from the config.
+    reloadedErrorsMux sync.Mutex
+    reloadedErrors map[string]dbgClient.Variable // Expression -> wrapped error it loaded, while paused.
+    loadLimitsMux sync.RWMutex
+    loadLimits config.LoadLimits
+    snippetsMux sync.Mutex
+    nextSnippetID int
+    snippets map[runtimeAgent.ScriptId]snippet // Compiled Go snippets devtools may run.
+    snippetRuns map[*exec.Cmd]context.CancelFunc // Snippets running right now.
 }
 
 func NewProxy(conn *shared.Connection, client *dbgClient.Client) *proxy {
     agent := runtimeAgent.NewAgent(conn)
-    return &proxy{
+    p := &proxy{
         conn: conn,
         agent: agent,
+        logAgent: logAgent.NewAgent(conn),
+        logObjects: newLogObjectStore(),
+        tracebacks: &tracebackCollector{},
+        callsiteWrites: newCallsiteQueue(),
         client: client,
+        objects: newObjectRegistry(),
+        temps: newTempStore(),
+        functionLocations: map[string]dbgClient.Location{},
+        typeInfos: map[string]typeInfo{},
+        packageNames: map[string]string{},
+        formattersEnabled: true,
+        userFormatters: map[string]*template.Template{},
+        reloadedErrors: map[string]dbgClient.Variable{},
+        snippets: map[runtimeAgent.ScriptId]snippet{},
+        snippetRuns: map[*exec.Cmd]context.CancelFunc{},
+        objectIdHandlers: map[string]func(runtimeAgent.GetPropertiesCommand){},
+        loadLimits: config.LoadLimits{
+            MaxStringLen: 500,
+            MaxArrayValues: 100,
+            CopyDepth: 10,
+        },
     }
+    p.SetObjectIdHandler(logObjectIdPrefix, p.getLogObjectPropertiesAndRespond)
+    p.SetObjectIdHandler(globalObjectId, p.getGlobalPropertiesAndRespond)
+    return p
+}
+
+// Lets other proxies handle getProperties for object ids they hand out. Ids are matched on prefix.
+func (p *proxy) SetObjectIdHandler(prefix string, handler func(runtimeAgent.GetPropertiesCommand)) {
+    p.objectIdHandlersMux.Lock()
+    defer p.objectIdHandlersMux.Unlock()
+    p.objectIdHandlers[prefix] = handler
 }
 
 func (p *proxy) CreateContext() {
+    if !atomic.CompareAndSwapInt32(&p.contextCreated, 0, 1) {
+        return
+    }
     p.agent.FireExecutionContextCreated(runtimeAgent.ExecutionContextCreatedEvent{
         Context: runtimeAgent.ExecutionContextDescription{
-            Id: 1,
+            Id: executionContextId,
             Origin: "://",
             Name: "Self",
         },
     })
 }
 
+func (p *proxy) DestroyContext() {
+    if !atomic.CompareAndSwapInt32(&p.contextCreated, 1, 0) {
+        return
+    }
+    // Stored values point into the process that is gone, and a restart may run a new build.
+    p.temps.Clear()
+    p.typeInfosMux.Lock()
+    p.typeInfos = map[string]typeInfo{}
+    p.packageSources = nil
+    p.packageNames = map[string]string{}
+    p.typeInfosMux.Unlock()
+    p.agent.FireExecutionContextDestroyed(runtimeAgent.ExecutionContextDestroyedEvent{
+        ExecutionContextId: executionContextId,
+    })
+}
+
+// Logs how the program ended and destroys its context. state is nil if delve did not say.
+func (p *proxy) ProcessExited(state *dbgClient.DebuggerState) {
+    // Delve can tell us about the exit before we read the last of the output, a panic in particular.
+    time.Sleep(tracebackFlushDelay)
+    p.flushTraceback()
+    consoleType := runtimeAgent.ConsoleAPICalledTypeInfo
+    message := "Process exited"
+    if state != nil {
+        if state.ExitStatus != 0 {
+            consoleType = runtimeAgent.ConsoleAPICalledTypeError
+        }
+        message = fmt.Sprintf("Process exited with status %d", state.ExitStatus)
+        if state.ExitStatus < 0 {
+            // Delve reports death by signal as the negative signal number.
+            message = fmt.Sprintf("Process was killed by signal %d (%v)", -state.ExitStatus, syscall.Signal(-state.ExitStatus))
+        }
+    }
+    p.agent.FireConsoleAPICalled(runtimeAgent.ConsoleAPICalledEvent{
+        Type: consoleType,
+        Args: []runtimeAgent.RemoteObject{
+            {
+                Type: runtimeAgent.RemoteObjectTypeString,
+                Value: message,
+            },
+        },
+        Timestamp: now(),
+        ExecutionContextId: executionContextId,
+    })
+    p.StopSnippets()
+    p.DestroyContext()
+}
+
+// Shows a message of the debugger itself in the console as an error.
+func (p *proxy) ConsoleError(message string) {
+    p.agent.FireConsoleAPICalled(runtimeAgent.ConsoleAPICalledEvent{
+        Type: runtimeAgent.ConsoleAPICalledTypeError,
+        Args: []runtimeAgent.RemoteObject{
+            {
+                Type: runtimeAgent.RemoteObjectTypeString,
+                Value: message,
+            },
+        },
+        Timestamp: now(),
+        ExecutionContextId: executionContextId,
+    })
+}
+
 func (p *proxy) Start() {
+    p.logAgent.SetEnableHandler(p.enableLogAndRespond)
+    p.logAgent.SetDisableHandler(p.disableLogAndRespond)
     // Wait until we are enabled.
     p.agent.SetEnableHandler(p.enableAndRespond)
 }
@@ -53,18 +200,28 @@ func (p *proxy) enableAndRespond(command runtimeAgent.EnableCommand) {
     }
 
     p.agent.SetGetPropertiesHandler(p.getPropertiesAndRespond)
+    p.agent.SetEvaluateHandler(p.evaluateAndRespond)
+    p.agent.SetCallFunctionOnHandler(p.callFunctionOnAndRespond)
+    p.agent.SetSetCustomObjectFormatterEnabledHandler(p.setCustomObjectFormatterEnabledAndRespond)
+    p.agent.SetReleaseObjectHandler(p.releaseObjectAndRespond)
+    p.agent.SetReleaseObjectGroupHandler(p.releaseObjectGroupAndRespond)
     p.agent.SetCompileScriptHandler(p.compileScriptAndRespond)
+    p.agent.SetRunScriptHandler(p.runScriptAndRespond)
+    p.agent.SetDisableHandler(p.disableAndRespond)
 
+    if p.Callsites() {
+        go shared.WrapFunctionForPanicRecover(p.installOutputTracepoint, p.conn)()
+    }
     go shared.WrapFunctionForPanicRecover(p.handleStdout, p.conn)()
     go shared.WrapFunctionForPanicRecover(p.handleStderr, p.conn)()
 }
 
-func (p *proxy) compileScriptAndRespond(command runtimeAgent.CompileScriptCommand) {
-    command.Respond(nil)
-}
-
 func (p *proxy) getPropertiesAndRespond(command runtimeAgent.GetPropertiesCommand) {
     objectId := string(command.ObjectId)
+    if strings.HasPrefix(objectId, objectIdPrefix) {
+        p.getObjectPropertiesAndRespond(command)
+        return
+    }
     if strings.HasPrefix(objectId, "local:") {
         var goroutineID int
         var err error
@@ -87,31 +244,42 @@ func (p *proxy) getPropertiesAndRespond(command runtimeAgent.GetPropertiesComman
         if err != nil {
             shared.ThrowError(err.Error())
         }
-        variables, err := p.client.ListLocalVariables(dbgClient.EvalScope{
+        scope := dbgClient.EvalScope{
             GoroutineID: goroutineID,
             Frame: frameId,
-        }, dbgClient.LoadConfig{
-            FollowPointers: true,
-            MaxVariableRecurse: 1,
-            MaxStringLen: 500,
-            MaxArrayValues: 1,
-            MaxStructFields: 1,
-        })
+        }
+        p.frameSelected(goroutineID, frameId)
+        variables, err := p.client.ListLocalVariables(scope, p.LoadConfig())
         if err != nil {
             shared.ThrowError(err.Error())
         }
         properties := []runtimeAgent.PropertyDescriptor{}
         for _, variable := range variables {
-            remoteObject := p.MakeRemoteObject(variable)
-            properties = append(properties, runtimeAgent.PropertyDescriptor{
-                Name: variable.Name,
-                Value: &remoteObject,
-            })
+            properties = append(properties, p.makeProperty(variable.Name, variable, &objectRef{
+                Scope: scope,
+                Expression: variable.Name,
+            }))
         }
         command.Respond(&runtimeAgent.GetPropertiesReturn{
             Result: properties,
         })
+        return
     }
+
+    var handler func(runtimeAgent.GetPropertiesCommand)
+    p.objectIdHandlersMux.RLock()
+    for prefix, fn := range p.objectIdHandlers {
+        if strings.HasPrefix(objectId, prefix) {
+            handler = fn
+            break
+        }
+    }
+    p.objectIdHandlersMux.RUnlock()
+    if handler == nil {
+        command.RespondWithError(shared.ErrorCodeInvalidParams, "Unknown objectId")
+        return
+    }
+    handler(command)
 }
 
 func (p *proxy) getAndSyncCloseState() bool {
@@ -130,39 +298,9 @@ func (p *proxy) handleStdout() {
     if err != nil {
         shared.ThrowError(err.Error())
     }
-    reader := bufio.NewReader(stdout)
-    for {
-        if p.getAndSyncCloseState() {
-            return
-        }
-        totalData := []byte{}
-        for notDone := true; notDone; {
-            var data []byte
-            var err error
-            data, notDone, err = reader.ReadLine()
-            totalData = append(totalData, data...)
-            if len(totalData) > 5e+6 { // 5 megabytes
-                fmt.Println("stdout too big!")
-                return
-            }
-            if err != nil {
-                shared.ThrowError(err.Error())
-            }
-        }
-
-        fmt.Println("Stdout: ", string(totalData))
-        p.agent.FireConsoleAPICalled(runtimeAgent.ConsoleAPICalledEvent{
-            Type: runtimeAgent.ConsoleAPICalledTypeLog,
-            Args: []runtimeAgent.RemoteObject{
-                {
-                    Type: runtimeAgent.RemoteObjectTypeString,
-                    Value: string(totalData),
-                },
-            },
-            Timestamp: 10000000,
-            ExecutionContextId: 5,
-        })
-    }
+    p.handleOutput("Stdout", stdout, func(line string) {
+        p.sendConsoleLine(runtimeAgent.ConsoleAPICalledTypeLog, line, p.callsite(1, line))
+    })
 }
 
 func (p *proxy) handleStderr() {
@@ -170,7 +308,13 @@ func (p *proxy) handleStderr() {
     if err != nil {
         shared.ThrowError(err.Error())
     }
-    reader := bufio.NewReader(stderr)
+    // Panics are written to stderr, so it also goes through the traceback parser.
+    p.handleOutput("Stderr", stderr, p.handleStderrLine)
+}
+
+// Hands every line of the stream to handleLine.
+func (p *proxy) handleOutput(name string, stream io.Reader, handleLine func(line string)) {
+    reader := bufio.NewReader(stream)
     for {
         if p.getAndSyncCloseState() {
             return
@@ -182,85 +326,114 @@ func (p *proxy) handleStderr() {
             data, notDone, err = reader.ReadLine()
             totalData = append(totalData, data...)
             if len(totalData) > 5e+6 { // 5 megabytes
-                fmt.Println("stderr too big!")
+                fmt.Println(name + " too big!")
                 return
             }
             if err != nil {
                 shared.ThrowError(err.Error())
             }
         }
-        fmt.Println("Stderr: ", string(totalData))
-        p.agent.FireConsoleAPICalled(runtimeAgent.ConsoleAPICalledEvent{
-            Type: runtimeAgent.ConsoleAPICalledTypeLog,
-            Args: []runtimeAgent.RemoteObject{
-                {
-                    Type: runtimeAgent.RemoteObjectTypeString,
-                    Value: string(totalData),
-                },
+
+        if p.Verbose() {
+            fmt.Println(name + ": ", string(totalData))
+        }
+        handleLine(string(totalData))
+    }
+}
+
+// Devtools timestamps are milliseconds since the epoch.
+func now() runtimeAgent.Timestamp {
+    return runtimeAgent.Timestamp(float64(time.Now().UnixNano()) / float64(time.Millisecond))
+}
+
+// stackTrace is where the line was printed from, nil if we do not know.
+func (p *proxy) sendConsoleLine(consoleType runtimeAgent.ConsoleAPICalledTypeEnum, line string, stackTrace *runtimeAgent.StackTrace) {
+    for _, parser := range logParsers {
+        if parsed, ok := parser.Parse(line); ok {
+            p.sendLogLine(consoleType, parsed, line, stackTrace)
+            return
+        }
+    }
+    p.agent.FireConsoleAPICalled(runtimeAgent.ConsoleAPICalledEvent{
+        Type: consoleType,
+        Args: []runtimeAgent.RemoteObject{
+            {
+                Type: runtimeAgent.RemoteObjectTypeString,
+                Value: line,
             },
-            ExecutionContextId: 0,
-        })
+        },
+        Timestamp: now(),
+        ExecutionContextId: executionContextId,
+        StackTrace: stackTrace,
+    })
+}
+
+func (p *proxy) Verbose() bool {
+    return atomic.LoadInt32(&p.verbose) == 1
+}
+
+// Verbose also prints the program's output to our own stdout.
+func (p *proxy) SetVerbose(verbose bool) {
+    if verbose {
+        atomic.StoreInt32(&p.verbose, 1)
+    } else {
+        atomic.StoreInt32(&p.verbose, 0)
     }
 }
 
-// TODO This is horrible, must fix!
+// Like MakeRemoteObject, but composite values get an object id so the frontend can expand them. Expression must
+// evaluate to the variable in scope.
+func (p *proxy) MakeScopedRemoteObject(variable dbgClient.Variable, scope dbgClient.EvalScope, expression string, objectGroup string) runtimeAgent.RemoteObject {
+    return p.makeRemoteObject(variable, &objectRef{
+        Scope: scope,
+        Expression: expression,
+        Group: objectGroup,
+    })
+}
+
 func (p *proxy) MakeRemoteObject(variable dbgClient.Variable) runtimeAgent.RemoteObject {
-    //name := variable.Name
-    kind := variable.Kind
-    outKind := runtimeAgent.RemoteObjectTypeSymbol
-    var subType runtimeAgent.RemoteObjectSubtypeEnum
-    var subTypePtr *runtimeAgent.RemoteObjectSubtypeEnum
-
-    previewType := runtimeAgent.ObjectPreviewTypeSymbol
-    var previewSubType runtimeAgent.ObjectPreviewSubtypeEnum
-    var previewSubTypePtr *runtimeAgent.ObjectPreviewSubtypeEnum
-    if kind == reflect.Bool {
-        outKind = runtimeAgent.RemoteObjectTypeBoolean
-    } else if kind == reflect.Int || kind == reflect.Int8 || kind == reflect.Int16 || kind == reflect.Int32 ||
-              kind == reflect.Int64 || kind == reflect.Uint || kind == reflect.Uint8 || kind == reflect.Uint16 ||
-              kind == reflect.Uint32 || kind == reflect.Uint64 || kind == reflect.Uintptr || kind == reflect.Float32 ||
-              kind == reflect.Float64 {
-        outKind = runtimeAgent.RemoteObjectTypeNumber
-    } else if kind == reflect.Complex64 || kind == reflect.Complex128 {
-    } else if kind == reflect.Array {
-        outKind = runtimeAgent.RemoteObjectTypeObject
-        subType = runtimeAgent.RemoteObjectSubtypeArray
-        subTypePtr = &subType
-
-        previewType = runtimeAgent.ObjectPreviewTypeObject
-        previewSubType = runtimeAgent.ObjectPreviewSubtypeArray
-        previewSubTypePtr = &previewSubType
-    } else if kind == reflect.Chan {
-    } else if kind == reflect.Func {
-        outKind = runtimeAgent.RemoteObjectTypeFunction
-    } else if kind == reflect.Interface {
-    } else if kind == reflect.Map {
-        outKind = runtimeAgent.RemoteObjectTypeObject
-        subType = runtimeAgent.RemoteObjectSubtypeMap
-        subTypePtr = &subType
-    } else if kind == reflect.Ptr {
-    } else if kind == reflect.Slice {
-        outKind = runtimeAgent.RemoteObjectTypeObject
-        subType = runtimeAgent.RemoteObjectSubtypeArray
-        subTypePtr = &subType
-    } else if kind == reflect.String {
-        outKind = runtimeAgent.RemoteObjectTypeString
-        previewType = runtimeAgent.ObjectPreviewTypeString
-    } else if kind == reflect.Struct {
-        outKind = runtimeAgent.RemoteObjectTypeObject
-    } else if kind == reflect.UnsafePointer {
+    return p.makeRemoteObject(variable, nil)
+}
+
+func (p *proxy) makeRemoteObject(variable dbgClient.Variable, ref *objectRef) runtimeAgent.RemoteObject {
+    outKind, subtype := remoteObjectType(variable)
+
+    var value interface{} = variable.Value
+    var description *string
+    switch {
+    case variable.Kind == reflect.Func:
+        text := p.describeFunction(variable)
+        description = &text
+        value = nil
+    case variable.Kind == reflect.Complex64 || variable.Kind == reflect.Complex128:
+        // There is no json for complex numbers and unserializableValue only takes javascript's, so they go as text.
+        text := complexValue(variable)
+        description = &text
+        value = text
+    case outKind == runtimeAgent.RemoteObjectTypeObject:
+        // Objects are shown by description, only primitives carry a value.
+        text := p.describeVariable(variable)
+        description = &text
+        value = nil
+    default:
+        // Named primitives like time.Duration keep their value, the frontend shows the description instead.
+        if text, ok := p.format(variable); ok {
+            description = &text
+        }
+    }
+
+    var objectId *runtimeAgent.RemoteObjectId
+    if ref != nil && isComposite(variable) {
+        id := p.objects.Register(*ref)
+        objectId = &id
     }
 
     return runtimeAgent.RemoteObject{
         Type: outKind,
-        Subtype: subTypePtr,
-        Value: variable.Value,
-        Preview: &runtimeAgent.ObjectPreview{
-            Type: previewType,
-            Subtype: previewSubTypePtr,
-            Overflow: false,
-            Properties: []runtimeAgent.PropertyPreview{},
-            // Entries: &[]runtimeAgent.EntryPreview{},
-        },
+        Subtype: subtype,
+        Value: value,
+        Description: description,
+        ObjectId: objectId,
+        Preview: p.makePreview(variable),
     }
 }
@@ -0,0 +1,258 @@
+package runtime
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "go/parser"
+    "go/token"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "github.com/allada/gdd/protocol/shared"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// Snippets are built from a file that //line directives make look like this one, so errors and panics point into
+// the snippet. We put the snippet's url back in their place.
+const snippetFile = "snippet.go"
+
+const snippetScriptIdPrefix = "snippet:"
+
+// Devtools gives snippets from the Sources panel urls like snippet:///name. Everything else it compiles, like each
+// line typed in the console, is javascript it only wants checked.
+const snippetURLPrefix = "snippet://"
+
+var compileErrorPattern = regexp.MustCompile(`^(?:\S*/)?snippet\.go:(\d+):(\d+): (.*)$`)
+var snippetPathPattern = regexp.MustCompile(`\S*snippet\.go:`)
+
+// A compiled snippet devtools may run.
+type snippet struct {
+    Dir string // Temp dir with the source and the binary.
+    URL string
+}
+
+func (s snippet) binary() string {
+    return filepath.Join(s.Dir, "snippet")
+}
+
+// Output of a snippet links to the snippet, so it can be told apart from the program's.
+func (s snippet) stackTrace() *runtimeAgent.StackTrace {
+    return &runtimeAgent.StackTrace{
+        CallFrames: []runtimeAgent.CallFrame{
+            {
+                FunctionName: "main.main",
+                ScriptId: runtimeAgent.ScriptId(s.URL),
+                Url: s.URL,
+            },
+        },
+    }
+}
+
+// Whether source is a whole file with a package clause, instead of statements for main.
+func isGoFile(source string) bool {
+    _, err := parser.ParseFile(token.NewFileSet(), snippetFile, source, parser.PackageClauseOnly)
+    return err == nil
+}
+
+// Go file for a snippet. A snippet is either a whole program or statements, after any imports, that become the body
+// of main().
+func snippetSource(source string) string {
+    if isGoFile(source) {
+        return fmt.Sprintf("//line %s:1:1\n%s\n", snippetFile, source)
+    }
+    lines := strings.Split(source, "\n")
+    body := 0
+    inImport := false
+Imports:
+    for ; body < len(lines); body++ {
+        line := strings.TrimSpace(lines[body])
+        switch {
+        case inImport:
+            inImport = !strings.HasPrefix(line, ")")
+        case strings.HasPrefix(line, "import"):
+            inImport = strings.HasSuffix(line, "(")
+        case line != "" && !strings.HasPrefix(line, "//"):
+            break Imports
+        }
+    }
+    return fmt.Sprintf("package main\n//line %s:1:1\n%s\nfunc main() {\n//line %s:%d:1\n%s\n}\n", snippetFile,
+                       strings.Join(lines[:body], "\n"), snippetFile, body + 1, strings.Join(lines[body:], "\n"))
+}
+
+// Compile errors as an exception at the first of them, with all of them in the description.
+func compileErrorDetails(output string, url string) *runtimeAgent.ExceptionDetails {
+    messages := []string{}
+    var first []string
+    for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+        if strings.HasPrefix(line, "#") || line == "" {
+            continue
+        }
+        if match := compileErrorPattern.FindStringSubmatch(line); match != nil && first == nil {
+            first = match
+        }
+        messages = append(messages, snippetPathPattern.ReplaceAllString(line, url + ":"))
+    }
+    description := strings.Join(messages, "\n")
+    details := exceptionDetails(fmt.Errorf("%s", description))
+    details.Url = &url
+    if first != nil {
+        lineNumber, _ := strconv.ParseInt(first[1], 10, 64)
+        columnNumber, _ := strconv.ParseInt(first[2], 10, 64)
+        details.Text = first[3]
+        details.LineNumber = lineNumber - 1
+        details.ColumnNumber = columnNumber - 1
+    }
+    return details
+}
+
+// Builds a Go snippet from devtools' Snippets with the go command on this machine. Only the standard library can
+// be imported, the snippet is built outside of any module.
+func (p *proxy) compileScriptAndRespond(command runtimeAgent.CompileScriptCommand) {
+    if !strings.HasPrefix(command.SourceURL, snippetURLPrefix) {
+        command.Respond(nil)
+        return
+    }
+    goCommand, err := exec.LookPath("go")
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, "Go snippets need the go command in PATH")
+        return
+    }
+    dir, err := ioutil.TempDir("", "gdd-snippet")
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    s := snippet{
+        Dir: dir,
+        URL: command.SourceURL,
+    }
+    if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(snippetSource(command.Expression)), 0644); err != nil {
+        os.RemoveAll(dir)
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    build := exec.Command(goCommand, "build", "-o", s.binary(), "main.go")
+    build.Dir = dir
+    if output, err := build.CombinedOutput(); err != nil {
+        os.RemoveAll(dir)
+        if len(output) == 0 {
+            output = []byte(err.Error())
+        }
+        command.Respond(&runtimeAgent.CompileScriptReturn{
+            ExceptionDetails: compileErrorDetails(string(output), s.URL),
+        })
+        return
+    }
+    if !command.PersistScript {
+        // Only checked for errors, devtools will not ask to run it.
+        os.RemoveAll(dir)
+        command.Respond(&runtimeAgent.CompileScriptReturn{})
+        return
+    }
+
+    p.snippetsMux.Lock()
+    p.nextSnippetID++
+    scriptId := runtimeAgent.ScriptId(snippetScriptIdPrefix + strconv.Itoa(p.nextSnippetID))
+    p.snippets[scriptId] = s
+    p.snippetsMux.Unlock()
+    command.Respond(&runtimeAgent.CompileScriptReturn{
+        ScriptId: &scriptId,
+    })
+}
+
+// Sends each line of a snippet's stdout or stderr to the console.
+func (p *proxy) streamSnippetOutput(s snippet, stream io.Reader, consoleType runtimeAgent.ConsoleAPICalledTypeEnum) {
+    scanner := bufio.NewScanner(stream)
+    scanner.Buffer(nil, 5e+6) // 5 megabytes, same as the program's output.
+    for scanner.Scan() {
+        line := snippetPathPattern.ReplaceAllString(scanner.Text(), s.URL + ":")
+        p.sendConsoleLine(consoleType, line, s.stackTrace())
+    }
+}
+
+// Runs a compiled snippet as its own process next to the program and answers once it exited.
+func (p *proxy) runScriptAndRespond(command runtimeAgent.RunScriptCommand) {
+    p.snippetsMux.Lock()
+    s, ok := p.snippets[command.ScriptId]
+    if !ok {
+        p.snippetsMux.Unlock()
+        command.RespondWithError(shared.ErrorCodeInvalidParams, "Could not find script with given id")
+        return
+    }
+    // Canceled by StopSnippets(), so a snippet that never ends does not outlive the session.
+    ctx, cancel := context.WithCancel(context.Background())
+    run := exec.CommandContext(ctx, s.binary())
+    p.snippetRuns[run] = cancel
+    p.snippetsMux.Unlock()
+    defer func() {
+        p.snippetsMux.Lock()
+        delete(p.snippetRuns, run)
+        p.snippetsMux.Unlock()
+        cancel()
+    }()
+
+    stdout, err := run.StdoutPipe()
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    stderr, err := run.StderrPipe()
+    if err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    if err := run.Start(); err != nil {
+        command.RespondWithError(shared.ErrorCodeInternalError, err.Error())
+        return
+    }
+    var wg sync.WaitGroup
+    wg.Add(2)
+    go func() {
+        defer wg.Done()
+        p.streamSnippetOutput(s, stdout, runtimeAgent.ConsoleAPICalledTypeLog)
+    }()
+    go func() {
+        defer wg.Done()
+        p.streamSnippetOutput(s, stderr, runtimeAgent.ConsoleAPICalledTypeError)
+    }()
+    // Wait() closes the pipes, everything has to be read before.
+    wg.Wait()
+    if err := run.Wait(); err != nil {
+        details := exceptionDetails(fmt.Errorf("Snippet failed: %s", err.Error()))
+        details.Url = &s.URL
+        command.Respond(&runtimeAgent.RunScriptReturn{
+            Result: *details.Exception,
+            ExceptionDetails: details,
+        })
+        return
+    }
+    command.Respond(&runtimeAgent.RunScriptReturn{
+        Result: undefinedObject(),
+    })
+}
+
+// Kills running snippets and removes every compiled one. Called when devtools disables the runtime, the program exits
+// or the frontend goes away.
+func (p *proxy) StopSnippets() {
+    p.snippetsMux.Lock()
+    defer p.snippetsMux.Unlock()
+    for _, cancel := range p.snippetRuns {
+        cancel()
+    }
+    for _, s := range p.snippets {
+        os.RemoveAll(s.Dir)
+    }
+    p.snippets = map[runtimeAgent.ScriptId]snippet{}
+}
+
+func (p *proxy) disableAndRespond(command runtimeAgent.DisableCommand) {
+    p.StopSnippets()
+    command.Respond()
+}
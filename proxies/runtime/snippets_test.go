@@ -0,0 +1,84 @@
+package runtime
+
+import (
+    "go/parser"
+    "go/token"
+    "testing"
+)
+
+func TestSnippetSource(t *testing.T) {
+    tests := []struct {
+        source string
+        want string
+    }{
+        {
+            "fmt.Println(1)",
+            "package main\n//line snippet.go:1:1\n\nfunc main() {\n//line snippet.go:1:1\nfmt.Println(1)\n}\n",
+        },
+        {
+            "import \"fmt\"\n\nfmt.Println(1)",
+            "package main\n//line snippet.go:1:1\nimport \"fmt\"\n\nfunc main() {\n//line snippet.go:3:1\nfmt.Println(1)\n}\n",
+        },
+        {
+            "// Prints.\nimport (\n    \"fmt\"\n    \"os\"\n)\nfmt.Fprintln(os.Stderr, 1)",
+            "package main\n//line snippet.go:1:1\n// Prints.\nimport (\n    \"fmt\"\n    \"os\"\n)\nfunc main() {\n" +
+            "//line snippet.go:6:1\nfmt.Fprintln(os.Stderr, 1)\n}\n",
+        },
+        {
+            "package main\n\nfunc main() {}",
+            "//line snippet.go:1:1\npackage main\n\nfunc main() {}\n",
+        },
+    }
+    for _, test := range tests {
+        got := snippetSource(test.source)
+        if got != test.want {
+            t.Errorf("snippetSource(%q) = %q, want %q", test.source, got, test.want)
+        }
+        if _, err := parser.ParseFile(token.NewFileSet(), snippetFile, got, 0); err != nil {
+            t.Errorf("snippetSource(%q) does not parse: %v", test.source, err)
+        }
+    }
+}
+
+func TestCompileErrorDetails(t *testing.T) {
+    const url = "snippet:///test"
+    tests := []struct {
+        output string
+        text string
+        description string
+        line int64
+        column int64
+    }{
+        {
+            output: "# command-line-arguments\n/tmp/gdd-snippet1/snippet.go:3:5: undefined: x\n" +
+                    "/tmp/gdd-snippet1/snippet.go:4:2: declared and not used: y\n",
+            text: "undefined: x",
+            description: url + ":3:5: undefined: x\n" + url + ":4:2: declared and not used: y",
+            line: 2,
+            column: 4,
+        },
+        {
+            output: "snippet.go:1:1: expected 'package', found x\n",
+            text: "expected 'package', found x",
+            description: url + ":1:1: expected 'package', found x",
+            line: 0,
+            column: 0,
+        },
+        {
+            output: "go: cannot find GOROOT directory\n",
+            text: "go: cannot find GOROOT directory",
+            description: "go: cannot find GOROOT directory",
+            line: -1,
+            column: -1,
+        },
+    }
+    for _, test := range tests {
+        details := compileErrorDetails(test.output, url)
+        if details.Text != test.text || *details.Exception.Description != test.description ||
+           details.LineNumber != test.line || details.ColumnNumber != test.column || *details.Url != url {
+            t.Errorf("compileErrorDetails(%q) = %q, %q, %d:%d, want %q, %q, %d:%d", test.output, details.Text,
+                     *details.Exception.Description, details.LineNumber, details.ColumnNumber, test.text,
+                     test.description, test.line, test.column)
+        }
+    }
+}
@@ -0,0 +1,75 @@
+package runtime
+
+import (
+    "strings"
+    "sync/atomic"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// Console input starting with this goes to the program's stdin, even while paused.
+const stdinPrefix = ">"
+
+// Typing this as stdin input closes stdin, like pressing Ctrl-D in a terminal.
+const stdinEOF = "^D"
+
+// The debugger tells us when the program runs. Expressions cannot be evaluated then, so console input is sent to
+// stdin instead.
+func (p *proxy) SetRunning(running bool) {
+    if running {
+        atomic.StoreInt32(&p.running, 1)
+    } else {
+        atomic.StoreInt32(&p.running, 0)
+    }
+}
+
+func (p *proxy) Running() bool {
+    return atomic.LoadInt32(&p.running) == 1
+}
+
+// Only what the user entered in the console can be meant for the program, the frontend also evaluates on its own
+// for completions and eager previews.
+func isUserEvaluation(command runtimeAgent.EvaluateCommand) bool {
+    return command.ObjectGroup != nil && *command.ObjectGroup == "console" &&
+        (command.Silent == nil || !*command.Silent) &&
+        (command.ThrowOnSideEffect == nil || !*command.ThrowOnSideEffect)
+}
+
+// Text to send to stdin if the console input is meant for it.
+func stdinInput(command runtimeAgent.EvaluateCommand, running bool) (string, bool) {
+    expression := command.Expression
+    if !isUserEvaluation(command) {
+        return "", false
+    }
+    if strings.HasPrefix(expression, stdinPrefix) {
+        input := strings.TrimPrefix(expression, stdinPrefix)
+        return strings.TrimPrefix(input, " "), true
+    }
+    return expression, running
+}
+
+func (p *proxy) writeStdin(input string) error {
+    stdin, err := p.client.GetStdin()
+    if err != nil {
+        return err
+    }
+    p.stdinMux.Lock()
+    defer p.stdinMux.Unlock()
+    if input == stdinEOF {
+        return stdin.Close()
+    }
+    _, err = stdin.Write([]byte(input + "\n"))
+    return err
+}
+
+func (p *proxy) sendStdinAndRespond(command runtimeAgent.EvaluateCommand, input string) {
+    if err := p.writeStdin(input); err != nil {
+        respondWithException(command, err)
+        return
+    }
+    // The console already shows what was typed, there is nothing to add to it.
+    command.Respond(&runtimeAgent.EvaluateReturn{
+        Result: runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeUndefined,
+        },
+    })
+}
@@ -0,0 +1,319 @@
+package runtime
+
+import (
+    "bytes"
+    "fmt"
+    "reflect"
+    "regexp"
+    "sort"
+    "strconv"
+    "sync"
+    "github.com/allada/gdd/dbgClient"
+    "github.com/allada/gdd/protocol/shared"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// What evaluate("this") gives the frontend. Devtools' "Store as global variable" stores the value on it.
+const globalObjectId = "global"
+
+// Console results we remember. $1 is the last one ($_ too), $2 the one before and so on.
+const maxConsoleResults = 10
+
+var globalThisPattern = regexp.MustCompile(`^\s*(this|globalThis)\s*;?\s*$`)
+
+// Devtools' saveVariable(): function(value) { ... this[name] = value; return name; }
+var saveVariablePattern = regexp.MustCompile(`\bthis\s*\[\s*\w+\s*\]\s*=`)
+
+var tempNamePattern = regexp.MustCompile(`\$(?:_|\d+)|\btemp\d+\b`)
+
+// A stored value. Values found by an address that may be on a goroutine's stack are only good until the program
+// runs again, the stack can be reused or moved after that.
+type tempValue struct {
+    Expression string
+    OnStack bool
+    Gone bool // Was on the stack and the program ran since.
+}
+
+// Values the console can refer to by name. Delve has no variables of its own, so each is kept as an expression
+// that finds the value by its address and works in any goroutine and frame.
+type tempStore struct {
+    mux sync.Mutex
+    results []tempValue // Last console result first.
+    saved map[string]tempValue // "temp1" -> value.
+}
+
+func newTempStore() *tempStore {
+    return &tempStore{
+        saved: map[string]tempValue{},
+    }
+}
+
+func (s *tempStore) AddResult(value tempValue) {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    s.results = append([]tempValue{value}, s.results...)
+    if len(s.results) > maxConsoleResults {
+        s.results = s.results[:maxConsoleResults]
+    }
+}
+
+// Stores value under the first free name, the same names devtools picks.
+func (s *tempStore) Save(value tempValue) string {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    index := 1
+    for {
+        if _, ok := s.saved["temp" + strconv.Itoa(index)]; !ok {
+            break
+        }
+        index++
+    }
+    name := "temp" + strconv.Itoa(index)
+    s.saved[name] = value
+    return name
+}
+
+func (s *tempStore) Get(name string) (tempValue, bool) {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    if name == "$_" {
+        name = "$1"
+    }
+    if name[0] == '$' {
+        index, err := strconv.Atoi(name[1:])
+        if err != nil || index < 1 || index > len(s.results) {
+            return tempValue{}, false
+        }
+        return s.results[index - 1], true
+    }
+    value, ok := s.saved[name]
+    return value, ok
+}
+
+// Names of the values that can still be used.
+func (s *tempStore) Names() []string {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    names := []string{}
+    if len(s.results) > 0 && !s.results[0].Gone {
+        names = append(names, "$_")
+    }
+    for i, value := range s.results {
+        if !value.Gone {
+            names = append(names, "$" + strconv.Itoa(i + 1))
+        }
+    }
+    saved := []string{}
+    for name, value := range s.saved {
+        if !value.Gone {
+            saved = append(saved, name)
+        }
+    }
+    sort.Strings(saved)
+    return append(names, saved...)
+}
+
+// Called when the program runs. Values on the stack are kept as gone, so $2 does not quietly become what $3 was.
+func (s *tempStore) ForgetStack() {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    for i := range s.results {
+        if s.results[i].OnStack {
+            s.results[i].Gone = true
+        }
+    }
+    for name, value := range s.saved {
+        if value.OnStack {
+            value.Gone = true
+            s.saved[name] = value
+        }
+    }
+}
+
+func (s *tempStore) Clear() {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    s.results = nil
+    s.saved = map[string]tempValue{}
+}
+
+// How to load variable again later, from wherever. Values without an address can only be kept if they can be written
+// as a literal. Any address may be on a stack, escape analysis puts values there that pointers point at too.
+func (p *proxy) stableValue(variable dbgClient.Variable) (tempValue, error) {
+    if variable.Kind == reflect.Ptr && !isNil(variable) {
+        return tempValue{
+            Expression: fmt.Sprintf("(%s)(%#x)", typeExpression(variable.Type), pointerValue(variable)),
+            OnStack: true,
+        }, nil
+    }
+    if variable.Addr != 0 {
+        return tempValue{
+            Expression: addressExpression(variable),
+            OnStack: true,
+        }, nil
+    }
+    switch variable.Kind {
+    case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint,
+         reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32,
+         reflect.Float64, reflect.String:
+        if !isTruncatedString(variable) {
+            w := &literalWriter{
+                p: p,
+                path: map[uint64]struct{}{},
+            }
+            return tempValue{
+                Expression: w.write(variable, true),
+            }, nil
+        }
+    }
+    return tempValue{}, fmt.Errorf("This value has no address, it cannot be stored.")
+}
+
+// Calls replace for the parts of expression that are not inside string or rune literals.
+func replaceOutsideStrings(expression string, replace func(string) string) string {
+    var out bytes.Buffer
+    start := 0
+    for i := 0; i < len(expression); i++ {
+        quote := expression[i]
+        if quote != '"' && quote != '\'' && quote != '`' {
+            continue
+        }
+        out.WriteString(replace(expression[start:i]))
+        end := i + 1
+        for end < len(expression) && expression[end] != quote {
+            if expression[end] == '\\' && quote != '`' {
+                end++
+            }
+            end++
+        }
+        if end < len(expression) {
+            end++
+        } else {
+            end = len(expression)
+        }
+        out.WriteString(expression[i:end])
+        start = end
+        i = end - 1
+    }
+    out.WriteString(replace(expression[start:]))
+    return out.String()
+}
+
+// Puts the stored expressions in place of $_, $1 and temp1 before delve sees them. A tempN nobody stored is left
+// alone, it may be a variable of the program.
+func (p *proxy) expandTemps(expression string) (string, error) {
+    var err error
+    expanded := replaceOutsideStrings(expression, func(code string) string {
+        return tempNamePattern.ReplaceAllStringFunc(code, func(name string) string {
+            stored, ok := p.temps.Get(name)
+            if !ok {
+                if name[0] == '$' && err == nil {
+                    err = fmt.Errorf("%s is not defined", name)
+                }
+                return name
+            }
+            if stored.Gone && err == nil {
+                err = fmt.Errorf("%s was on the stack and is gone since the program ran.", name)
+            }
+            return "(" + stored.Expression + ")"
+        })
+    })
+    return expanded, err
+}
+
+// Console results become $1 (and $_), if we can find them again.
+func (p *proxy) rememberResult(variable dbgClient.Variable) {
+    if variable.Kind == reflect.Invalid {
+        return
+    }
+    if value, err := p.stableValue(variable); err == nil {
+        p.temps.AddResult(value)
+    }
+}
+
+func (p *proxy) globalObject() runtimeAgent.RemoteObject {
+    className := "global"
+    objectId := runtimeAgent.RemoteObjectId(globalObjectId)
+    return runtimeAgent.RemoteObject{
+        Type: runtimeAgent.RemoteObjectTypeObject,
+        ClassName: &className,
+        Description: &className,
+        ObjectId: &objectId,
+    }
+}
+
+// Value of a call argument, either one of our objects or a plain json value.
+func (p *proxy) argumentValue(argument runtimeAgent.CallArgument) (tempValue, error) {
+    if argument.ObjectId == nil {
+        switch value := argument.Value.(type) {
+        case string:
+            return tempValue{Expression: strconv.Quote(value)}, nil
+        case float64:
+            return tempValue{Expression: strconv.FormatFloat(value, 'g', -1, 64)}, nil
+        case bool:
+            return tempValue{Expression: strconv.FormatBool(value)}, nil
+        }
+        return tempValue{}, fmt.Errorf("Only go values can be stored.")
+    }
+    ref, ok := p.objects.Get(*argument.ObjectId)
+    if !ok || ref.IsRange {
+        return tempValue{}, fmt.Errorf("Could not find object with given id")
+    }
+    variable, err := p.client.EvalVariable(ref.Scope, ref.Expression, p.LoadConfig())
+    if err != nil {
+        return tempValue{}, err
+    }
+    return p.stableValue(*variable)
+}
+
+// "Store as global variable". Devtools evaluates the name it gets back right after.
+func (p *proxy) saveVariableAndRespond(command runtimeAgent.CallFunctionOnCommand) {
+    if !saveVariablePattern.MatchString(command.FunctionDeclaration) || command.Arguments == nil || len(*command.Arguments) != 1 {
+        command.RespondWithError(shared.ErrorCodeInvalidParams, "Only values can be stored on the global object")
+        return
+    }
+    value, err := p.argumentValue((*command.Arguments)[0])
+    if err != nil {
+        details := exceptionDetails(err)
+        command.Respond(&runtimeAgent.CallFunctionOnReturn{
+            Result: *details.Exception,
+            ExceptionDetails: details,
+        })
+        return
+    }
+    command.Respond(&runtimeAgent.CallFunctionOnReturn{
+        Result: runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeString,
+            Value: p.temps.Save(value),
+        },
+    })
+}
+
+// Lists the stored values as properties of the global object, which is also what the console autocompletes from.
+func (p *proxy) getGlobalPropertiesAndRespond(command runtimeAgent.GetPropertiesCommand) {
+    properties := []runtimeAgent.PropertyDescriptor{}
+    scope, scopeErr := p.evalScope(command.DestinationTargetID)
+    for _, name := range p.temps.Names() {
+        value, ok := p.temps.Get(name)
+        if !ok {
+            continue
+        }
+        expression := value.Expression
+        if scopeErr != nil {
+            properties = append(properties, syntheticProperty(name, scopeErr.Error()))
+            continue
+        }
+        variable, err := p.client.EvalVariable(scope, expression, p.LoadConfig())
+        if err != nil {
+            properties = append(properties, syntheticProperty(name, err.Error()))
+            continue
+        }
+        properties = append(properties, p.makeProperty(name, *variable, &objectRef{
+            Scope: scope,
+            Expression: expression,
+        }))
+    }
+    command.Respond(&runtimeAgent.GetPropertiesReturn{
+        Result: properties,
+    })
+}
@@ -0,0 +1,57 @@
+package runtime
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestReplaceOutsideStrings(t *testing.T) {
+    tests := []struct {
+        expression string
+        want string
+    }{
+        {`a + b`, `A + B`},
+        {`a + "b"`, `A + "b"`},
+        {`"a" + b + 'c'`, `"a" + B + 'c'`},
+        {`"say \"a\"" + a`, `"say \"a\"" + A`},
+        {"`a\\` + a", "`a\\` + A"},
+        {`'\'' + a`, `'\'' + A`},
+        {`a + "unterminated`, `A + "unterminated`},
+        {``, ``},
+    }
+    for _, test := range tests {
+        if got := replaceOutsideStrings(test.expression, strings.ToUpper); got != test.want {
+            t.Errorf("replaceOutsideStrings(%q) = %q, want %q", test.expression, got, test.want)
+        }
+    }
+}
+
+func TestExpandTemps(t *testing.T) {
+    p := &proxy{temps: newTempStore()}
+    p.temps.AddResult(tempValue{Expression: "old"})
+    p.temps.AddResult(tempValue{Expression: "*(*int)(0xc000012345)", OnStack: true})
+    p.temps.Save(tempValue{Expression: `"kept"`})
+    p.temps.Save(tempValue{Expression: "(*main.T)(0xc000054321)", OnStack: true})
+    p.temps.ForgetStack()
+    p.temps.AddResult(tempValue{Expression: "1"})
+
+    tests := []struct {
+        expression string
+        want string
+        err bool
+    }{
+        {"$_ + $1", "(1) + (1)", false},
+        {"$3 * 2", "(old) * 2", false},
+        {`temp1 + "$1 temp1"`, `("kept") + "$1 temp1"`, false},
+        {"temp3 + x", "temp3 + x", false},
+        {"temp2.Name", "((*main.T)(0xc000054321)).Name", true},
+        {"$2", "(*(*int)(0xc000012345))", true},
+        {"$9", "$9", true},
+    }
+    for _, test := range tests {
+        got, err := p.expandTemps(test.expression)
+        if got != test.want || (err != nil) != test.err {
+            t.Errorf("expandTemps(%q) = %q, %v, want %q, error %v", test.expression, got, err, test.want, test.err)
+        }
+    }
+}
@@ -0,0 +1,398 @@
+package runtime
+
+import (
+    "bytes"
+    "fmt"
+    "go/ast"
+    "go/build"
+    "go/parser"
+    "go/token"
+    "io/ioutil"
+    "path"
+    "path/filepath"
+    "reflect"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode"
+    "github.com/allada/gdd/dbgClient"
+    runtimeAgent "github.com/allada/gdd/protocol/runtime"
+)
+
+// Module cache directories have the version in them, github.com/a/b@v1.2.3/c is package github.com/a/b/c.
+var moduleVersionPattern = regexp.MustCompile(`@[^/]+`)
+
+// What we know about a named type that delve does not tell us. Types do not change, so this is looked up once.
+type typeInfo struct {
+    Tags []logField // Field name -> struct tag, for fields that have one.
+    Methods []string // Like "String" or "(*T).Set".
+}
+
+// Splits "example.com/pkg.Name[int]" into its package path and name. Only named types have them.
+func splitTypeName(typeName string) (string, string, bool) {
+    if bracket := strings.IndexByte(typeName, '['); bracket > 0 {
+        typeName = typeName[:bracket]
+    }
+    if strings.ContainsAny(typeName, " *(){}") || strings.HasPrefix(typeName, "[") {
+        return "", "", false
+    }
+    dot := strings.LastIndexByte(typeName, '.')
+    if dot <= 0 || dot == len(typeName) - 1 {
+        return "", "", false
+    }
+    return typeName[:dot], typeName[dot + 1:], true
+}
+
+func (p *proxy) typeInfo(typeName string) typeInfo {
+    p.typeInfosMux.Lock()
+    info, ok := p.typeInfos[typeName]
+    p.typeInfosMux.Unlock()
+    if ok {
+        return info
+    }
+    info = typeInfo{}
+    if pkg, name, ok := splitTypeName(typeName); ok {
+        info.Tags = p.structTags(pkg, name)
+        info.Methods = p.methods(pkg, name)
+    }
+    p.typeInfosMux.Lock()
+    p.typeInfos[typeName] = info
+    p.typeInfosMux.Unlock()
+    return info
+}
+
+// Import path of the package in dir, worked out from where it sits: a vendor directory, the module cache, GOROOT,
+// a module of its own or GOPATH. modules caches the module path of the go.mod found for a directory.
+func importPath(dir string, modules map[string]string) (string, bool) {
+    dir = filepath.ToSlash(dir)
+    if index := strings.LastIndex(dir, "/vendor/"); index >= 0 {
+        return dir[index + len("/vendor/"):], true
+    }
+    if index := strings.Index(dir, "/pkg/mod/"); index >= 0 && moduleVersionPattern.MatchString(dir) {
+        return unescapeModulePath(moduleVersionPattern.ReplaceAllString(dir[index + len("/pkg/mod/"):], "")), true
+    }
+    if goroot := filepath.ToSlash(build.Default.GOROOT); goroot != "" && strings.HasPrefix(dir, goroot + "/src/") {
+        return strings.TrimPrefix(dir, goroot + "/src/"), true
+    }
+    for moduleDir := dir; moduleDir != "/" && moduleDir != "."; moduleDir = path.Dir(moduleDir) {
+        module, ok := modules[moduleDir]
+        if !ok {
+            module = readModulePath(filepath.FromSlash(moduleDir))
+            modules[moduleDir] = module
+        }
+        if module != "" {
+            return path.Join(module, strings.TrimPrefix(dir, moduleDir)), true
+        }
+    }
+    for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+        if src := filepath.ToSlash(gopath) + "/src/"; strings.HasPrefix(dir, src) {
+            return strings.TrimPrefix(dir, src), true
+        }
+    }
+    return "", false
+}
+
+// Module path from the go.mod in dir, empty if there is none.
+func readModulePath(dir string) string {
+    data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+    if err != nil {
+        return ""
+    }
+    for _, line := range strings.Split(string(data), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) >= 2 && fields[0] == "module" {
+            return strings.Trim(fields[1], `"`)
+        }
+    }
+    return ""
+}
+
+// The module cache writes upper case letters as ! and the lower case letter, github.com/!burnt!sushi is BurntSushi.
+func unescapeModulePath(escaped string) string {
+    var out bytes.Buffer
+    for i := 0; i < len(escaped); i++ {
+        if escaped[i] == '!' && i + 1 < len(escaped) {
+            i++
+            out.WriteString(strings.ToUpper(escaped[i:i + 1]))
+            continue
+        }
+        out.WriteByte(escaped[i])
+    }
+    return out.String()
+}
+
+// Source files of package pkg, out of the files the program was built from. The files are sorted by package the first
+// time, after that this is a lookup.
+func (p *proxy) packageFiles(pkg string) []string {
+    p.typeInfosMux.Lock()
+    packages := p.packageSources
+    p.typeInfosMux.Unlock()
+    if packages == nil {
+        sources, err := p.client.ListSources()
+        if err != nil {
+            return nil
+        }
+        packages = map[string][]string{}
+        modules := map[string]string{}
+        for _, file := range sources {
+            if !strings.HasSuffix(file, ".go") {
+                continue
+            }
+            if dirPackage, ok := importPath(filepath.Dir(file), modules); ok {
+                packages[dirPackage] = append(packages[dirPackage], file)
+            }
+        }
+        // Main packages are named main whatever their directory.
+        locations, err := p.client.FindLocation(dbgClient.EvalScope{GoroutineID: -1}, "main.main")
+        if err == nil && len(locations) > 0 {
+            mainDir := filepath.Dir(locations[0].File)
+            for _, file := range sources {
+                if filepath.Dir(file) == mainDir && strings.HasSuffix(file, ".go") {
+                    packages["main"] = append(packages["main"], file)
+                }
+            }
+        }
+        p.typeInfosMux.Lock()
+        p.packageSources = packages
+        p.typeInfosMux.Unlock()
+    }
+    return packages[pkg]
+}
+
+// What go tooling takes the name of a package to be when it cannot look: the end of its path, without a major version
+// and without go- in front. Stops at the first character a name cannot have, gopkg.in/yaml.v2 is yaml.
+func assumedPackageName(importPath string) string {
+    base := path.Base(importPath)
+    if strings.HasPrefix(base, "v") {
+        if _, err := strconv.Atoi(base[1:]); err == nil && path.Dir(importPath) != "." {
+            base = path.Base(path.Dir(importPath))
+        }
+    }
+    base = strings.TrimPrefix(base, "go-")
+    if i := strings.IndexFunc(base, func(r rune) bool {
+        return !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+    }); i >= 0 {
+        base = base[:i]
+    }
+    return base
+}
+
+// Name from the package clause of the package at importPath, for writing its types in go source.
+func (p *proxy) packageName(importPath string) string {
+    if !strings.Contains(importPath, "/") {
+        // The standard library, whose packages are named after their path.
+        return importPath
+    }
+    p.typeInfosMux.Lock()
+    name, ok := p.packageNames[importPath]
+    p.typeInfosMux.Unlock()
+    if ok {
+        return name
+    }
+    name = assumedPackageName(importPath)
+    for _, file := range p.packageFiles(importPath) {
+        if parsed, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly); err == nil {
+            name = parsed.Name.Name
+            break
+        }
+    }
+    p.typeInfosMux.Lock()
+    p.packageNames[importPath] = name
+    p.typeInfosMux.Unlock()
+    return name
+}
+
+// Struct tags are not in the debug info, they are read from the type's source instead.
+func (p *proxy) structTags(pkg string, name string) []logField {
+    tags := []logField{}
+    fileSet := token.NewFileSet()
+    // Only files that mention the type are parsed, a package can have a lot of them.
+    declaration := regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\s+(\[[^\]]*\]\s*)?struct\b`)
+    for _, file := range p.packageFiles(pkg) {
+        source, err := ioutil.ReadFile(file)
+        if err != nil || !declaration.Match(source) {
+            continue
+        }
+        parsed, err := parser.ParseFile(fileSet, file, source, 0)
+        if err != nil {
+            continue
+        }
+        found := false
+        ast.Inspect(parsed, func(node ast.Node) bool {
+            spec, ok := node.(*ast.TypeSpec)
+            if found || !ok || spec.Name.Name != name {
+                return !found
+            }
+            found = true
+            structType, ok := spec.Type.(*ast.StructType)
+            if !ok {
+                return false
+            }
+            for _, field := range structType.Fields.List {
+                if field.Tag == nil {
+                    continue
+                }
+                tag, err := strconv.Unquote(field.Tag.Value)
+                if err != nil {
+                    continue
+                }
+                for _, fieldName := range fieldNames(field) {
+                    tags = append(tags, logField{fieldName, tag})
+                }
+            }
+            return false
+        })
+        if found {
+            break
+        }
+    }
+    return tags
+}
+
+// Embedded fields are named after their type.
+func fieldNames(field *ast.Field) []string {
+    names := []string{}
+    for _, name := range field.Names {
+        names = append(names, name.Name)
+    }
+    if len(names) > 0 {
+        return names
+    }
+    fieldType := field.Type
+    if star, ok := fieldType.(*ast.StarExpr); ok {
+        fieldType = star.X
+    }
+    switch fieldType := fieldType.(type) {
+    case *ast.Ident:
+        return []string{fieldType.Name}
+    case *ast.SelectorExpr:
+        return []string{fieldType.Sel.Name}
+    }
+    return names
+}
+
+// Methods of the type, from the functions delve knows. Functions the linker threw away are not there.
+func (p *proxy) methods(pkg string, name string) []string {
+    filter := fmt.Sprintf(`^%s\.(\(\*)?%s(\[.*\])?\)?\.[^.]+$`, regexp.QuoteMeta(pkg), regexp.QuoteMeta(name))
+    functions, err := p.client.ListFunctions(filter)
+    if err != nil {
+        return nil
+    }
+    prefix := pkg + "."
+    valueMethods := map[string]struct{}{}
+    pointerMethods := []string{}
+    for _, function := range functions {
+        receiver := strings.TrimPrefix(function, prefix)
+        method := receiver[strings.LastIndexByte(receiver, '.') + 1:]
+        if strings.HasPrefix(receiver, "(*") {
+            pointerMethods = append(pointerMethods, method)
+        } else {
+            valueMethods[method] = struct{}{}
+        }
+    }
+    methods := []string{}
+    for method := range valueMethods {
+        methods = append(methods, method)
+    }
+    for _, method := range pointerMethods {
+        // The compiler makes pointer wrappers for value methods, those are the same method.
+        if _, ok := valueMethods[method]; !ok {
+            methods = append(methods, "(*" + name + ")." + method)
+        }
+    }
+    sort.Strings(methods)
+    return methods
+}
+
+func stringInternal(name string, value string) runtimeAgent.InternalPropertyDescriptor {
+    return runtimeAgent.InternalPropertyDescriptor{
+        Name: name,
+        Value: &runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeString,
+            Value: value,
+        },
+    }
+}
+
+func numberInternal(name string, value int64) runtimeAgent.InternalPropertyDescriptor {
+    return runtimeAgent.InternalPropertyDescriptor{
+        Name: name,
+        Value: &runtimeAgent.RemoteObject{
+            Type: runtimeAgent.RemoteObjectTypeNumber,
+            Value: value,
+        },
+    }
+}
+
+func addressInternal(name string, address uint64) runtimeAgent.InternalPropertyDescriptor {
+    return stringInternal(name, fmt.Sprintf("%#x", address))
+}
+
+// What devtools shows as [[...]] under an expanded value. [[Data]] is where the bytes or elements of a string or
+// slice are, slices with the same [[Data]] share their backing array.
+func (p *proxy) internalProperties(variable dbgClient.Variable, targetID string) []runtimeAgent.InternalPropertyDescriptor {
+    internal := []runtimeAgent.InternalPropertyDescriptor{
+        stringInternal("[[Type]]", variable.Type),
+        stringInternal("[[Kind]]", variable.Kind.String()),
+    }
+    if variable.Addr != 0 {
+        internal = append(internal, addressInternal("[[Address]]", uint64(variable.Addr)))
+    }
+    switch variable.Kind {
+    case reflect.String:
+        internal = append(internal, numberInternal("[[Len]]", variable.Len))
+        if variable.Base != 0 {
+            internal = append(internal, addressInternal("[[Data]]", uint64(variable.Base)))
+        }
+    case reflect.Slice:
+        internal = append(internal, numberInternal("[[Len]]", variable.Len), numberInternal("[[Cap]]", variable.Cap))
+        if variable.Base != 0 {
+            internal = append(internal, addressInternal("[[Data]]", uint64(variable.Base)))
+        }
+    case reflect.Array, reflect.Map:
+        internal = append(internal, numberInternal("[[Len]]", variable.Len))
+    case reflect.Chan:
+        info := loadChanInfo(variable)
+        internal = append(internal, numberInternal("[[Len]]", info.Len), numberInternal("[[Cap]]", info.Cap))
+    case reflect.Ptr, reflect.UnsafePointer:
+        internal = append(internal, addressInternal("[[Target]]", pointerValue(variable)))
+    case reflect.Interface:
+        if !isNil(variable) {
+            internal = append(internal, stringInternal("[[DynamicType]]", variable.Children[0].Type))
+        }
+    case reflect.Func:
+        if location, ok := p.functionLocationInternal(variable, targetID); ok {
+            internal = append(internal, location)
+        }
+    }
+    if variable.Unreadable != "" {
+        internal = append(internal, stringInternal("[[Unreadable]]", variable.Unreadable))
+    }
+
+    // Pointers and interfaces are expanded as the value they hold, so that is whose tags and methods are shown.
+    target := variable
+    for (target.Kind == reflect.Ptr || target.Kind == reflect.Interface) && !isNil(target) {
+        target = children(target)[0]
+    }
+    info := p.typeInfo(target.Type)
+    if target.Kind == reflect.Struct && len(info.Tags) > 0 {
+        tags := p.jsonObject(info.Tags, "Tags", nil)
+        internal = append(internal, runtimeAgent.InternalPropertyDescriptor{
+            Name: "[[Tags]]",
+            Value: &tags,
+        })
+    }
+    if len(info.Methods) > 0 {
+        methods := []interface{}{}
+        for _, method := range info.Methods {
+            methods = append(methods, method)
+        }
+        methodsObject := p.jsonRemoteObject(methods)
+        internal = append(internal, runtimeAgent.InternalPropertyDescriptor{
+            Name: "[[Methods]]",
+            Value: &methodsObject,
+        })
+    }
+    return internal
+}